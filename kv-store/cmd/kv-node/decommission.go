@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"kv-store/internal/cluster"
+	"kv-store/internal/hashring"
+	"kv-store/internal/raft"
+	"kv-store/internal/rebalance"
+)
+
+// rejoinRetryDelay - пауза между попытками вернуть ноду в кластер после неудачного вывода
+const rejoinRetryDelay = 2 * time.Second
+
+// leaveCluster - источник состава кластера (seed или gossip), которому
+// нода сообщает, что уходит или (если вывод не удался) остается
+type leaveCluster interface {
+	Leave() (cluster.Topology, error)
+	Rejoin() error
+}
+
+// decommissioner выводит ноду из кластера: seed или gossip-группа помечает ее как уходящую,
+// кольцо пересобирается без нее, данные переезжают к новым владельцам,
+// и только после этого нода завершается
+type decommissioner struct {
+	membership leaveCluster
+	ring       *hashring.HashRing
+	rebalancer *rebalance.Service
+	consensus  *raft.Host // nil - режим eventual
+	replicas   int
+
+	mu      sync.Mutex
+	started bool
+	// done получает результат каждой попытки вывода: nil - данные перенесены
+	done chan error
+}
+
+func newDecommissioner(membership leaveCluster, ring *hashring.HashRing, rebalancer *rebalance.Service, consensus *raft.Host, replicas int) *decommissioner {
+	return &decommissioner{
+		membership: membership,
+		ring:       ring,
+		rebalancer: rebalancer,
+		consensus:  consensus,
+		replicas:   replicas,
+		done:       make(chan error, 1),
+	}
+}
+
+// Start начинает вывод ноды. Повторный вызов ничего не делает.
+func (d *decommissioner) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started {
+		return nil
+	}
+	if d.ring.Size() < 2 {
+		return errors.New("no other nodes to take over the data")
+	}
+
+	topology, err := d.membership.Leave()
+	if err != nil {
+		return fmt.Errorf("leave cluster: %w", err)
+	}
+	d.started = true
+	log.Println("Decommissioning: node left the ring, moving data to new owners")
+
+	d.ring.UpdateRing(topology)
+	if d.consensus != nil {
+		syncRaftMembers(d.consensus, d.ring, d.replicas)
+	}
+	go d.drain()
+	return nil
+}
+
+// Done сообщает результат вывода ноды. nil - данные перенесены и нода может
+// завершиться; ошибка - вывод не удался, нода вернулась в кластер и продолжает
+// работать (вывод можно запустить снова).
+func (d *decommissioner) Done() <-chan error {
+	return d.done
+}
+
+func (d *decommissioner) drain() {
+	start := time.Now()
+
+	var err error
+	if d.consensus != nil {
+		// Данные групп уже есть на остальных участниках, достаточно выйти из состава
+		err = d.consensus.Leave(context.Background())
+	} else {
+		err = d.rebalancer.Drain(context.Background())
+	}
+	if err != nil {
+		log.Printf("ERR: Decommission failed: %v, returning node to the cluster", err)
+		d.rejoin()
+		d.done <- err
+		return
+	}
+	log.Printf("Decommission finished in %v", time.Since(start))
+	d.done <- nil
+}
+
+// rejoin снимает пометку об уходе, чтобы остальные ноды вернули эту в кольцо,
+// и разрешает повторный вывод. Данные, которые не успели перенести, остаются на ноде.
+func (d *decommissioner) rejoin() {
+	for {
+		err := d.membership.Rejoin()
+		if err == nil {
+			break
+		}
+		log.Printf("ERR: Failed to return node to the cluster: %v, retrying in %v", err, rejoinRetryDelay)
+		time.Sleep(rejoinRetryDelay)
+	}
+	log.Println("Node returned to the cluster")
+
+	d.mu.Lock()
+	d.started = false
+	d.mu.Unlock()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"kv-store/internal/cluster"
+	"kv-store/internal/config"
+	"kv-store/internal/gossip"
+	"kv-store/internal/peer"
+)
+
+// startGossip входит в gossip-группу через ноды, которые знает seed, и
+// возвращает канал со списками нод от gossip. Списки от seed дальше служат
+// только контактами для входа: так нода находит группу, даже если
+// разошлась с ней, пока была недоступна.
+func startGossip(cfg *config.Config, myID string, contacts []cluster.NodeInfo, seedUpdates <-chan cluster.Topology) (*gossip.Service, chan cluster.Topology) {
+	self := cluster.NodeInfo{ID: myID}
+	for _, n := range contacts {
+		if n.ID == myID {
+			// Адрес ноды - тот, по которому ее видит seed
+			self.Addr = n.Addr
+		}
+	}
+	if self.Addr == "" {
+		log.Fatalf("gossip: seed did not report the address of node %s", myID)
+	}
+
+	members := gossip.NewService(gossip.Config{
+		Self:             self,
+		ProbeInterval:    time.Duration(cfg.Gossip.ProbeIntervalMs) * time.Millisecond,
+		ProbeTimeout:     time.Duration(cfg.Gossip.ProbeTimeoutMs) * time.Millisecond,
+		IndirectChecks:   cfg.Gossip.IndirectChecks,
+		SuspicionTimeout: time.Duration(cfg.Gossip.SuspicionTimeoutMs) * time.Millisecond,
+		SyncInterval:     time.Duration(cfg.Gossip.SyncIntervalMs) * time.Millisecond,
+	}, peer.NewClient(5*time.Second))
+
+	if n := members.Join(contacts); n > 0 {
+		log.Printf("[Gossip] Joined the cluster via %d node(s)", n)
+	}
+	go func() {
+		for t := range seedUpdates {
+			members.Join(t.Nodes)
+		}
+	}()
+
+	updates := make(chan cluster.Topology, 10)
+	go members.Start(updates)
+	return members, updates
+}
@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"kv-store/internal/rebalance"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"kv-store/internal/cluster"
 	"kv-store/internal/config"
+	"kv-store/internal/gossip"
+	"kv-store/internal/handoff"
 	"kv-store/internal/hashring"
 	"kv-store/internal/httpapi"
 	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+	"kv-store/internal/raft"
+	"kv-store/internal/txn"
+	"kv-store/internal/wal"
 )
 
 const (
 	heartbeatInterval = 5 * time.Second
+
+	// shutdownTimeout - сколько ждать завершения запросов в обработке при остановке
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
@@ -29,42 +43,242 @@ func main() {
 		log.Fatalf("load config: %v", err)
 	}
 
-	store := kv.NewStore()
+	engine, err := kv.OpenEngine(kv.Options{
+		Engine:       cfg.Storage.Engine,
+		DataDir:      cfg.Storage.DataDir,
+		Sync:         wal.SyncPolicy(cfg.Storage.Fsync),
+		SyncInterval: time.Duration(cfg.Storage.FsyncIntervalMs) * time.Millisecond,
+
+		SnapshotInterval: time.Duration(cfg.Storage.SnapshotIntervalSec) * time.Second,
+		SnapshotRetain:   cfg.Storage.SnapshotRetain,
+		MemtableSize:     cfg.Storage.MemtableSizeMB << 20,
+	})
+	if err != nil {
+		log.Fatalf("open storage engine: %v", err)
+	}
+
+	store := kv.NewStore(engine)
+	defer store.Close()
+
 	ring := hashring.New(cfg.Hash.VNodesPerNode)
 
-	dc := cluster.NewDiscoveryClient(cfg.Cluster.SeedAddr)
-	nodesChan := make(chan []cluster.NodeInfo, 10)
+	nodeID := cfg.Cluster.NodeID
+	if nodeID != "" {
+		if err := cluster.ValidateNodeID(nodeID); err != nil {
+			log.Fatalf("cluster.node_id: %v", err)
+		}
+	} else if cfg.Storage.DataDir != "" {
+		nodeID, err = cluster.LoadNodeID(cfg.Storage.DataDir)
+		if err != nil {
+			log.Fatalf("load node id: %v", err)
+		}
+	}
+
+	dc := cluster.NewDiscoveryClient(cfg.Cluster.SeedAddrs, nodeID, cfg.Cluster.WatchTopology)
+	nodesChan := make(chan cluster.Topology, 10)
 
 	log.Println("Starting discovery...")
 	go dc.Start(heartbeatInterval, nodesChan)
 
 	log.Println("Waiting for initial registration...")
-	initialNodes := <-nodesChan
+	initial := <-nodesChan
 
 	myID := dc.GetMyID()
 	log.Printf("Node initialized. ID: %s", myID)
 
-	rebalancer := rebalance.NewService(store, ring, hashring.NodeID(myID))
-	go rebalancer.Start()
+	var members *gossip.Service
+	if cfg.Cluster.Membership == config.MembershipGossip {
+		// Seed остается только источником контактов, состав кластера ведет gossip
+		members, nodesChan = startGossip(cfg, myID, initial.Nodes, nodesChan)
+		initial = cluster.Topology{Nodes: members.Nodes()}
+	}
+
+	hintDir := ""
+	if cfg.Storage.DataDir != "" {
+		hintDir = filepath.Join(cfg.Storage.DataDir, "hints")
+	}
+	hints, err := handoff.NewService(store, ring, hintDir)
+	if err != nil {
+		log.Fatalf("open hint log: %v", err)
+	}
+	go hints.Start()
+
+	defer hints.Stop()
+
+	raftMode := cfg.Consistency.Mode == config.ConsistencyRaft
+
+	rebalancer := rebalance.NewService(store, ring, hashring.NodeID(myID), cfg.Hash.ReplicationFactor, hints)
+	if !raftMode {
+		// В режиме raft данные между нодами переносят группы Raft
+		go rebalancer.Start()
+		hints.OnFold(rebalancer.Trigger)
+	}
 
 	defer rebalancer.Stop()
 
-	ring.UpdateRing(initialNodes)
+	ring.UpdateRing(initial)
+
+	var consensus *raft.Host
+	if raftMode {
+		consensus, err = openRaft(cfg, myID, store, ring)
+		if err != nil {
+			log.Fatalf("open raft groups: %v", err)
+		}
+		syncRaftMembers(consensus, ring, cfg.Hash.ReplicationFactor)
+		go consensus.Start()
+
+		defer consensus.Stop()
+	}
 
 	go func() {
-		for nodes := range nodesChan {
-			ring.UpdateRing(nodes)
-			log.Printf("Cluster updated. Peers: %d", len(nodes))
+		for topology := range nodesChan {
+			if !ring.UpdateRing(topology) {
+				continue
+			}
+			log.Printf("Cluster updated. Peers: %d, epoch %d", len(topology.Nodes), topology.Epoch)
+			if consensus != nil {
+				syncRaftMembers(consensus, ring, cfg.Hash.ReplicationFactor)
+				continue
+			}
 			go rebalancer.Trigger()
+			go hints.Trigger()
 		}
 	}()
 
-	h := httpapi.NewHandler(store, ring, hashring.NodeID(myID))
+	txnDir := ""
+	if cfg.Storage.DataDir != "" {
+		txnDir = filepath.Join(cfg.Storage.DataDir, "txn")
+	}
+	txnLog, err := txn.OpenLog(txnDir)
+	if err != nil {
+		log.Fatalf("open transaction log: %v", err)
+	}
+	defer txnLog.Close()
+
+	h := httpapi.NewHandler(store, ring, hashring.NodeID(myID), cfg.Hash, hints, txnLog, consensus, members)
+
+	txns := txn.NewService(txnLog, h.ResolveTxn)
+	go txns.Start()
+
+	defer txns.Stop()
+
+	var membership leaveCluster = dc
+	if members != nil {
+		membership = members
+	}
+	leaver := newDecommissioner(membership, ring, rebalancer, consensus, cfg.Hash.ReplicationFactor)
+	h.OnDecommission(leaver.Start)
+	if members == nil {
+		h.OnStaleTopology(dc.Refresh)
+	}
+
 	router := httpapi.NewRouter(h)
 
-	srvAddr := fmt.Sprintf(":%s", cfg.Cluster.Port)
-	log.Printf("HTTP API listening on %s", srvAddr)
-	if err := http.ListenAndServe(srvAddr, router); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: fmt.Sprintf(":%s", cfg.Cluster.Port), Handler: router}
+	srv.RegisterOnShutdown(h.CloseStreams)
+	log.Printf("HTTP API listening on %s", srv.Addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// SIGTERM (например, docker compose stop) сначала выводит ноду из кластера
+	// так же, как POST /admin/decommission; SIGINT и повторный сигнал
+	// останавливают ноду сразу, прерывая перенос данных
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	waitForExit(sigCh, leaver)
+
+	shutdown(srv, dc, members)
+}
+
+// waitForExit возвращается, когда нода должна остановиться: данные перенесены
+// выводом из кластера, пришел SIGINT или повторный сигнал во время вывода.
+// Если вывод не удался, нода остается в кластере и продолжает работать.
+func waitForExit(sigCh <-chan os.Signal, leaver *decommissioner) {
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig != syscall.SIGTERM {
+				return
+			}
+			log.Println("SIGTERM received, decommissioning node")
+			if err := leaver.Start(); err != nil {
+				log.Printf("ERR: Decommission failed: %v", err)
+				return
+			}
+			select {
+			case err := <-leaver.Done():
+				if err == nil {
+					return
+				}
+				log.Println("Node keeps serving, send SIGINT to stop it without moving data")
+			case <-sigCh:
+				log.Println("Second signal received, interrupting decommission")
+				return
+			}
+		case err := <-leaver.Done():
+			if err == nil {
+				return
+			}
+		}
+	}
+}
+
+// shutdown сообщает gossip-группе об уходе, перестает слать heartbeat, снимает
+// ноду с учета в seed и дожидается запросов в обработке. Фоновые сервисы
+// и хранилище останавливают defer в main.
+func shutdown(srv *http.Server, dc *cluster.DiscoveryClient, members *gossip.Service) {
+	log.Println("Shutting down...")
+	if members != nil {
+		members.Stop()
+	}
+	dc.Stop()
+	if err := dc.Deregister(); err != nil {
+		log.Printf("ERR: Deregister from seed failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("ERR: HTTP server shutdown: %v", err)
+	}
+}
+
+// openRaft открывает группы Raft (по одной на шард) с журналами в data_dir/raft
+func openRaft(cfg *config.Config, myID string, store *kv.Store, ring *hashring.HashRing) (*raft.Host, error) {
+	dir := ""
+	if cfg.Storage.DataDir != "" {
+		dir = filepath.Join(cfg.Storage.DataDir, "raft")
+	}
+	shards := cfg.Consistency.Shards
+	return raft.NewHost(
+		raft.Config{
+			Groups:            shards,
+			Self:              myID,
+			Dir:               dir,
+			BootstrapSize:     cfg.Hash.ReplicationFactor,
+			HeartbeatInterval: time.Duration(cfg.Consistency.HeartbeatMs) * time.Millisecond,
+			ElectionTimeout:   time.Duration(cfg.Consistency.ElectionTimeoutMs) * time.Millisecond,
+		},
+		kv.NewMachine(store, func(key string) int { return hashring.Shard(key, shards) }),
+		peer.NewClient(5*time.Second),
+		func(id string) (string, bool) { return ring.GetNodeAddr(hashring.NodeID(id)) },
+	)
+}
+
+// syncRaftMembers задает состав каждой группы: replication_factor нод кольца от позиции шарда
+func syncRaftMembers(consensus *raft.Host, ring *hashring.HashRing, rf int) {
+	for shard := 0; shard < consensus.Groups(); shard++ {
+		nodes, err := ring.ShardNodes(shard, rf)
+		if err != nil {
+			continue
+		}
+		members := make([]string, len(nodes))
+		for i, id := range nodes {
+			members[i] = string(id)
+		}
+		consensus.SetMembers(shard, members)
 	}
 }
@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,51 +15,137 @@ import (
 var ErrUnauthorized = errors.New("node unauthorized")
 
 type DiscoveryClient struct {
-	seedURL string
-	myID    string
-	mu      sync.RWMutex
-	client  *http.Client
+	seeds  []string
+	myID   string
+	cur    int // индекс seed, который последним ответил
+	mu     sync.RWMutex
+	client *http.Client
+
+	// watch - состав приходит через long-poll /watch-topology, а heartbeat
+	// только подтверждает, что нода жива
+	watch       bool
+	watchClient *http.Client
+
+	// refresh - внеочередной запрос состава (см. Refresh)
+	refresh chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-func NewDiscoveryClient(seedAddr string) *DiscoveryClient {
+const (
+	// refreshInterval - не чаще скольких раз в секунду состав запрашивается вне очереди
+	refreshInterval = time.Second
+
+	// watchRequestTimeout - предел одного long-poll; seed отвечает раньше (через 30 секунд)
+	watchRequestTimeout = 45 * time.Second
+)
+
+// NewDiscoveryClient создает клиента группы seed. Запросы идут seed,
+// который ответил последним; если он недоступен, клиент пробует следующий,
+// а не лидер группы перенаправляет запрос лидеру. nodeID - постоянный id
+// ноды; пустой - id выдаст seed при регистрации. watch - получать состав
+// через long-poll сразу после изменения, а не с очередным heartbeat.
+func NewDiscoveryClient(seedAddrs []string, nodeID string, watch bool) *DiscoveryClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &DiscoveryClient{
-		seedURL: "http://" + seedAddr,
-		client:  &http.Client{Timeout: 3 * time.Second},
+		seeds:       seedAddrs,
+		myID:        nodeID,
+		refresh:     make(chan struct{}, 1),
+		client:      &http.Client{Timeout: 3 * time.Second},
+		watch:       watch,
+		watchClient: &http.Client{Timeout: watchRequestTimeout},
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
 	}
 }
 
-func (d *DiscoveryClient) Start(interval time.Duration, updates chan<- []NodeInfo) {
-	d.ensureRegistered()
+func (d *DiscoveryClient) Start(interval time.Duration, updates chan<- Topology) {
+	defer close(d.done)
+	if !d.ensureRegistered() {
+		return
+	}
+
+	if d.watch {
+		watchDone := make(chan struct{})
+		go func(updates chan<- Topology) {
+			defer close(watchDone)
+			d.watchTopology(updates)
+		}(updates)
+		defer func() { <-watchDone }()
+		// Ответы heartbeat в этом режиме состав не обновляют
+		updates = nil
+	}
 
 	d.doHeartbeat(updates)
+	last := time.Now()
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.refresh:
+			if time.Since(last) < refreshInterval {
+				continue
+			}
+		}
 		d.doHeartbeat(updates)
+		last = time.Now()
+	}
+}
+
+// Refresh просит получить состав у seed, не дожидаясь очередного heartbeat,
+// например, когда другая нода сообщила о более новой эпохе. В режиме watch
+// не нужен: новый состав и так приходит сразу.
+func (d *DiscoveryClient) Refresh() {
+	if d.watch {
+		return
+	}
+	select {
+	case d.refresh <- struct{}{}:
+	default:
 	}
 }
 
+// Stop прекращает heartbeat и ждет завершения цикла Start. После этого
+// нода не зарегистрируется в seed заново, даже если seed ее забыл.
+func (d *DiscoveryClient) Stop() {
+	d.cancel()
+	<-d.done
+}
+
 func (d *DiscoveryClient) GetMyID() string {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 	return d.myID
 }
 
-func (d *DiscoveryClient) ensureRegistered() {
+// ensureRegistered повторяет регистрацию, пока она не пройдет. false - клиент остановлен.
+func (d *DiscoveryClient) ensureRegistered() bool {
 	for {
 		if err := d.register(); err == nil {
 			log.Printf("[Discovery] Registered successfully. ID: %s", d.GetMyID())
-			return
+			return true
+		}
+		select {
+		case <-d.ctx.Done():
+			return false
+		case <-time.After(2 * time.Second):
 		}
-		time.Sleep(2 * time.Second)
 	}
 }
 
 func (d *DiscoveryClient) register() error {
-	body := []byte("{}")
-	resp, err := d.client.Post(d.seedURL+"/register", "application/json", bytes.NewReader(body))
+	// Нода с постоянным id регистрируется под ним же, в том числе после
+	// потери сессии; id, выданный seed, тоже переиспользуется
+	body, _ := json.Marshal(registerRequest{ID: d.GetMyID()})
+	resp, err := d.do(d.ctx, "/register", body)
 	if err != nil {
 		return err
 	}
@@ -84,19 +171,27 @@ func (d *DiscoveryClient) register() error {
 	return nil
 }
 
-func (d *DiscoveryClient) doHeartbeat(updates chan<- []NodeInfo) {
+func (d *DiscoveryClient) doHeartbeat(updates chan<- Topology) {
 	nodes, err := d.heartbeat()
 
 	if errors.Is(err, ErrUnauthorized) {
 		log.Println("[Discovery] Session lost. Re-registering...")
-		d.ensureRegistered()
+		if !d.ensureRegistered() {
+			return
+		}
 		nodes, err = d.heartbeat()
 	}
 
+	if d.ctx.Err() != nil {
+		return
+	}
 	if err != nil {
 		log.Printf("[Discovery] Heartbeat error: %v", err)
 		return
 	}
+	if updates == nil {
+		return
+	}
 
 	select {
 	case updates <- nodes:
@@ -104,35 +199,178 @@ func (d *DiscoveryClient) doHeartbeat(updates chan<- []NodeInfo) {
 	}
 }
 
-func (d *DiscoveryClient) heartbeat() ([]NodeInfo, error) {
+func (d *DiscoveryClient) heartbeat() (Topology, error) {
+	return d.post(d.ctx, "/heartbeat")
+}
+
+// Leave сообщает seed, что нода выводится из кластера, и возвращает новый
+// список нод. Остальные ноды исключат ее из кольца со следующим heartbeat.
+func (d *DiscoveryClient) Leave() (Topology, error) {
+	return d.post(context.Background(), "/leave")
+}
+
+// Rejoin снимает пометку об уходе, если вывод ноды не удался: повторная
+// регистрация под тем же id возвращает ноду в список
+func (d *DiscoveryClient) Rejoin() error {
+	if err := d.register(); err != nil {
+		return err
+	}
+	d.Refresh()
+	return nil
+}
+
+// Deregister удаляет ноду из seed. Остальные ноды убирают ее из кольца
+// со следующим heartbeat. Вызывать после Stop, иначе heartbeat
+// зарегистрирует ноду заново.
+func (d *DiscoveryClient) Deregister() error {
+	id := d.GetMyID()
+	if id == "" {
+		return errors.New("no ID")
+	}
+
+	reqPayload, _ := json.Marshal(heartbeatRequest{ID: id})
+	resp, err := d.do(context.Background(), "/deregister", reqPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 401 - seed уже забыл ноду
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	log.Println("[Discovery] Deregistered")
+	return nil
+}
+
+// watchTopology ждет изменений состава на seed (long-poll) и сразу отправляет
+// новый состав в updates
+func (d *DiscoveryClient) watchTopology(updates chan<- Topology) {
+	var since uint64
+	for d.ctx.Err() == nil {
+		resp, err := d.send(d.ctx, d.watchClient, http.MethodGet, fmt.Sprintf("/watch-topology?since=%d", since), nil)
+		var t Topology
+		if err == nil {
+			t, err = decodeTopology(resp)
+		}
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Discovery] Watch topology error: %v", err)
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if t.Epoch == since {
+			// Истекло ожидание на seed, состав не менялся
+			continue
+		}
+		since = t.Epoch
+
+		select {
+		case updates <- t:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// post отправляет seed запрос от имени ноды и разбирает список активных нод
+func (d *DiscoveryClient) post(ctx context.Context, path string) (Topology, error) {
 	id := d.GetMyID()
 	if id == "" {
-		return nil, errors.New("no ID")
+		return Topology{}, errors.New("no ID")
 	}
 
 	reqPayload, _ := json.Marshal(heartbeatRequest{ID: id})
-	resp, err := d.client.Post(d.seedURL+"/heartbeat", "application/json", bytes.NewReader(reqPayload))
+	resp, err := d.do(ctx, path, reqPayload)
 	if err != nil {
-		return nil, err
+		return Topology{}, err
 	}
+	return decodeTopology(resp)
+}
+
+// decodeTopology разбирает список нод из ответа seed и закрывает тело ответа
+func decodeTopology(resp *http.Response) (Topology, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, ErrUnauthorized
+		return Topology{}, ErrUnauthorized
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %d", resp.StatusCode)
+		return Topology{}, fmt.Errorf("status %d", resp.StatusCode)
 	}
 
 	var res heartbeatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return nil, err
+		return Topology{}, err
 	}
 
 	infos := make([]NodeInfo, len(res.ActiveNodes))
 	for i, n := range res.ActiveNodes {
-		infos[i] = NodeInfo{ID: n.ID, Addr: n.Addr}
+		infos[i] = NodeInfo{ID: n.ID, Addr: n.Addr, Leaving: n.Leaving}
+	}
+
+	return Topology{Epoch: res.Epoch, Nodes: infos}, nil
+}
+
+// do отправляет POST группе seed, начиная с seed, который ответил последним.
+// Недоступный seed и seed без лидера (503) пропускаются. Перенаправление
+// к лидеру выполняет http.Client, и следующий запрос сразу пойдет лидеру.
+func (d *DiscoveryClient) do(ctx context.Context, path string, payload []byte) (*http.Response, error) {
+	return d.send(ctx, d.client, http.MethodPost, path, payload)
+}
+
+func (d *DiscoveryClient) send(ctx context.Context, client *http.Client, method, path string, payload []byte) (*http.Response, error) {
+	d.mu.RLock()
+	start := d.cur
+	d.mu.RUnlock()
+
+	var lastErr error
+	for i := 0; i < len(d.seeds); i++ {
+		addr := d.seeds[(start+i)%len(d.seeds)]
+		req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+path, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = fmt.Errorf("seed %s: %w", addr, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("seed %s: status %d", addr, resp.StatusCode)
+			continue
+		}
+		d.remember(resp.Request.URL.Host)
+		return resp, nil
 	}
+	if lastErr == nil {
+		lastErr = errors.New("no seed addresses")
+	}
+	return nil, lastErr
+}
 
-	return infos, nil
+// remember запоминает seed, который ответил
+func (d *DiscoveryClient) remember(addr string) {
+	for i, s := range d.seeds {
+		if s == addr {
+			d.mu.Lock()
+			d.cur = i
+			d.mu.Unlock()
+			return
+		}
+	}
 }
@@ -1,5 +1,9 @@
 package cluster
 
+type registerRequest struct {
+	ID string `json:"id,omitempty"`
+}
+
 type registerResponse struct {
 	ID string `json:"id"`
 }
@@ -9,15 +13,27 @@ type heartbeatRequest struct {
 }
 
 type nodeDTO struct {
-	ID   string `json:"id"`
-	Addr string `json:"addr"`
+	ID      string `json:"id"`
+	Addr    string `json:"addr"`
+	Leaving bool   `json:"leaving"`
 }
 
 type heartbeatResponse struct {
+	Epoch       uint64    `json:"epoch"`
 	ActiveNodes []nodeDTO `json:"active_nodes"`
 }
 
 type NodeInfo struct {
 	ID   string
 	Addr string
+	// Leaving - нода выводится из кластера: доступна по адресу, но не владеет ключами
+	Leaving bool
+}
+
+// Topology - список нод и его эпоха. Seed повышает эпоху при каждом изменении
+// состава, поэтому из двух списков новее тот, у которого эпоха больше.
+// Epoch 0 - версия неизвестна (состав ведет gossip).
+type Topology struct {
+	Epoch uint64
+	Nodes []NodeInfo
 }
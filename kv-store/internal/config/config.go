@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v3"
@@ -11,15 +12,64 @@ type ClusterConfig struct {
 	Port              string `yaml:"port"`
 	HealthIntervalSec int    `yaml:"health_interval_sec"`
 	SeedAddr          string `yaml:"seed_addr"`
+	// SeedAddrs - адреса всех seed группы; если не заданы, используется SeedAddr
+	SeedAddrs []string `yaml:"seed_addrs"`
+	// NodeID - постоянный id ноды; если не задан, id хранится в storage.data_dir
+	NodeID string `yaml:"node_id"`
+	// Membership - откуда берется состав кластера: seed или gossip
+	Membership string `yaml:"membership"`
+	// WatchTopology - получать состав от seed через long-poll сразу после изменения
+	WatchTopology bool `yaml:"watch_topology"`
 }
 
 type HashConfig struct {
-	VNodesPerNode int `yaml:"vnodes_per_node"`
+	VNodesPerNode     int `yaml:"vnodes_per_node"`
+	ReplicationFactor int `yaml:"replication_factor"`
+	ReadQuorum        int `yaml:"read_quorum"`
+	WriteQuorum       int `yaml:"write_quorum"`
+}
+
+type StorageConfig struct {
+	Engine              string `yaml:"engine"`
+	DataDir             string `yaml:"data_dir"`
+	Fsync               string `yaml:"fsync"`
+	FsyncIntervalMs     int    `yaml:"fsync_interval_ms"`
+	SnapshotIntervalSec int    `yaml:"snapshot_interval_sec"`
+	SnapshotRetain      int    `yaml:"snapshot_retain"`
+	MemtableSizeMB      int    `yaml:"memtable_size_mb"`
+}
+
+const (
+	ConsistencyEventual = "eventual"
+	ConsistencyRaft     = "raft"
+)
+
+type ConsistencyConfig struct {
+	Mode              string `yaml:"mode"`
+	Shards            int    `yaml:"shards"`
+	HeartbeatMs       int    `yaml:"heartbeat_ms"`
+	ElectionTimeoutMs int    `yaml:"election_timeout_ms"`
+}
+
+const (
+	MembershipSeed   = "seed"
+	MembershipGossip = "gossip"
+)
+
+type GossipConfig struct {
+	ProbeIntervalMs    int `yaml:"probe_interval_ms"`
+	ProbeTimeoutMs     int `yaml:"probe_timeout_ms"`
+	IndirectChecks     int `yaml:"indirect_checks"`
+	SuspicionTimeoutMs int `yaml:"suspicion_timeout_ms"`
+	SyncIntervalMs     int `yaml:"sync_interval_ms"`
 }
 
 type Config struct {
-	Cluster ClusterConfig `yaml:"cluster"`
-	Hash    HashConfig    `yaml:"hash"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Hash        HashConfig        `yaml:"hash"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Consistency ConsistencyConfig `yaml:"consistency"`
+	Gossip      GossipConfig      `yaml:"gossip"`
 }
 
 func Load(path string) (*Config, error) {
@@ -31,5 +81,64 @@ func Load(path string) (*Config, error) {
 	if err := yaml.Unmarshal(b, &cfg); err != nil {
 		return nil, err
 	}
+	if len(cfg.Cluster.SeedAddrs) == 0 && cfg.Cluster.SeedAddr != "" {
+		cfg.Cluster.SeedAddrs = []string{cfg.Cluster.SeedAddr}
+	}
+	if len(cfg.Cluster.SeedAddrs) == 0 {
+		return nil, fmt.Errorf("no seed addresses configured")
+	}
+	if cfg.Hash.ReplicationFactor <= 0 {
+		cfg.Hash.ReplicationFactor = 1
+	}
+	if cfg.Hash.ReadQuorum <= 0 {
+		cfg.Hash.ReadQuorum = 1
+	}
+	if cfg.Hash.WriteQuorum <= 0 {
+		cfg.Hash.WriteQuorum = 1
+	}
+	if cfg.Hash.ReadQuorum > cfg.Hash.ReplicationFactor {
+		return nil, fmt.Errorf("read_quorum %d exceeds replication_factor %d", cfg.Hash.ReadQuorum, cfg.Hash.ReplicationFactor)
+	}
+	if cfg.Hash.WriteQuorum > cfg.Hash.ReplicationFactor {
+		return nil, fmt.Errorf("write_quorum %d exceeds replication_factor %d", cfg.Hash.WriteQuorum, cfg.Hash.ReplicationFactor)
+	}
+	switch cfg.Consistency.Mode {
+	case "":
+		cfg.Consistency.Mode = ConsistencyEventual
+	case ConsistencyEventual, ConsistencyRaft:
+	default:
+		return nil, fmt.Errorf("unknown consistency mode %q", cfg.Consistency.Mode)
+	}
+	if cfg.Consistency.Shards <= 0 {
+		cfg.Consistency.Shards = 16
+	}
+	if cfg.Consistency.HeartbeatMs <= 0 {
+		cfg.Consistency.HeartbeatMs = 100
+	}
+	if cfg.Consistency.ElectionTimeoutMs <= 0 {
+		cfg.Consistency.ElectionTimeoutMs = 1000
+	}
+	switch cfg.Cluster.Membership {
+	case "":
+		cfg.Cluster.Membership = MembershipSeed
+	case MembershipSeed, MembershipGossip:
+	default:
+		return nil, fmt.Errorf("unknown membership mode %q", cfg.Cluster.Membership)
+	}
+	if cfg.Gossip.ProbeIntervalMs <= 0 {
+		cfg.Gossip.ProbeIntervalMs = 1000
+	}
+	if cfg.Gossip.ProbeTimeoutMs <= 0 || cfg.Gossip.ProbeTimeoutMs >= cfg.Gossip.ProbeIntervalMs {
+		cfg.Gossip.ProbeTimeoutMs = cfg.Gossip.ProbeIntervalMs / 2
+	}
+	if cfg.Gossip.IndirectChecks <= 0 {
+		cfg.Gossip.IndirectChecks = 3
+	}
+	if cfg.Gossip.SuspicionTimeoutMs <= 0 {
+		cfg.Gossip.SuspicionTimeoutMs = 5000
+	}
+	if cfg.Gossip.SyncIntervalMs <= 0 {
+		cfg.Gossip.SyncIntervalMs = 10000
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadYAML(t *testing.T, body string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoadRejectsQuorumAboveReplicationFactor(t *testing.T) {
+	for _, q := range []string{"read_quorum", "write_quorum"} {
+		_, err := loadYAML(t, "cluster:\n  seed_addr: seed:9000\nhash:\n  replication_factor: 2\n  "+q+": 3\n")
+		if err == nil || !strings.Contains(err.Error(), q) {
+			t.Fatalf("%s > replication_factor: err = %v", q, err)
+		}
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := loadYAML(t, "cluster:\n  seed_addr: seed:9000\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hash.ReplicationFactor != 1 || cfg.Hash.ReadQuorum != 1 || cfg.Hash.WriteQuorum != 1 {
+		t.Fatalf("hash = %+v, want RF=R=W=1", cfg.Hash)
+	}
+	if len(cfg.Cluster.SeedAddrs) != 1 || cfg.Cluster.SeedAddrs[0] != "seed:9000" {
+		t.Fatalf("seed_addrs = %v", cfg.Cluster.SeedAddrs)
+	}
+	if cfg.Consistency.Mode != ConsistencyEventual || cfg.Cluster.Membership != MembershipSeed {
+		t.Fatalf("modes = %q, %q", cfg.Consistency.Mode, cfg.Cluster.Membership)
+	}
+}
@@ -0,0 +1,555 @@
+// Package gossip ведет состав кластера без центрального сервиса, по схеме SWIM.
+// Каждый период нода проверяет одну ноду из состава (ping); если та не ответила,
+// просит нескольких других проверить ее (indirect ping), и только потом
+// объявляет подозреваемой. Подозреваемая нода, которая не опровергла
+// подозрение за SuspicionTimeout, считается мертвой. Обновления состава
+// передаются попутно с проверками, а периодический обмен полным составом
+// (sync) догоняет пропущенное. Seed нужен только, чтобы найти первые контакты.
+package gossip
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"kv-store/internal/cluster"
+)
+
+const (
+	// retransmitMult - сколько раз (умножить на log10 размера группы) передается обновление
+	retransmitMult = 4
+	// maxPiggyback - сколько обновлений добавляется к одному сообщению
+	maxPiggyback = 8
+	// deadRetention - сколько помнить мертвые и ушедшие ноды, чтобы старые
+	// слухи о них не вернули их в состав
+	deadRetention = time.Minute
+	// announcePeers - скольким нодам уходящая нода сообщает об этом напрямую
+	announcePeers = 3
+)
+
+type Config struct {
+	// Self - id и адрес этой ноды
+	Self cluster.NodeInfo
+
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	IndirectChecks   int
+	SuspicionTimeout time.Duration
+	SyncInterval     time.Duration
+}
+
+type memberState struct {
+	Member
+	// changedAt - когда нода стала suspect, dead или left
+	changedAt time.Time
+}
+
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// Service - участие ноды в gossip-группе
+type Service struct {
+	self      string
+	cfg       Config
+	transport Transport
+
+	mu         sync.Mutex
+	members    map[string]*memberState
+	queue      map[string]*broadcast // последнее обновление о каждой ноде, которое еще рассылается
+	probeOrder []string
+	probeNext  int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewService(cfg Config, transport Transport) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		self:      cfg.Self.ID,
+		cfg:       cfg,
+		transport: transport,
+		members:   make(map[string]*memberState),
+		queue:     make(map[string]*broadcast),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	me := Member{ID: cfg.Self.ID, Addr: cfg.Self.Addr, State: StateAlive}
+	s.members[me.ID] = &memberState{Member: me}
+	s.enqueue(me)
+	return s
+}
+
+// Start проверяет ноды группы и отправляет в updates текущий список живых нод
+// (включая подозреваемые - до подтверждения они остаются на кольце)
+func (s *Service) Start(updates chan<- cluster.Topology) {
+	defer close(s.done)
+	log.Printf("[Gossip] Started as %s (%s)", s.self, s.cfg.Self.Addr)
+
+	probe := time.NewTicker(s.cfg.ProbeInterval)
+	defer probe.Stop()
+	syncTicker := time.NewTicker(s.cfg.SyncInterval)
+	defer syncTicker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-probe.C:
+			s.probe()
+			s.reap()
+		case <-syncTicker.C:
+			if m, ok := s.randomMember(); ok {
+				_ = s.sync(m.Addr)
+			}
+		}
+
+		select {
+		case updates <- cluster.Topology{Nodes: s.Nodes()}:
+		default:
+		}
+	}
+}
+
+// Stop сообщает группе, что нода ушла, и прекращает проверки
+func (s *Service) Stop() {
+	s.mu.Lock()
+	me := s.members[s.self]
+	me.Incarnation++
+	me.State = StateLeft
+	s.enqueue(me.Member)
+	peers := s.pickMembers(announcePeers, "")
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			_ = s.sync(addr)
+		}(p.Addr)
+	}
+	wg.Wait()
+
+	s.cancel()
+	<-s.done
+}
+
+// Join обменивается составом с нодами, которых еще нет в группе. Возвращает,
+// сколько из них ответило.
+func (s *Service) Join(contacts []cluster.NodeInfo) int {
+	var addrs []string
+	s.mu.Lock()
+	for _, c := range contacts {
+		if _, known := s.members[c.ID]; !known && c.ID != s.self {
+			addrs = append(addrs, c.Addr)
+		}
+	}
+	s.mu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	joined := 0
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := s.sync(addr); err != nil {
+				log.Printf("[Gossip] Join via %s failed: %v", addr, err)
+				return
+			}
+			mu.Lock()
+			joined++
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return joined
+}
+
+// Leave помечает ноду как выводимую из кластера и возвращает новый список нод.
+// Остальные ноды исключат ее из кольца, когда до них дойдет обновление.
+func (s *Service) Leave() (cluster.Topology, error) {
+	s.mu.Lock()
+	me := s.members[s.self]
+	if !me.Leaving {
+		me.Leaving = true
+		me.Incarnation++
+		s.enqueue(me.Member)
+	}
+	s.mu.Unlock()
+	return cluster.Topology{Nodes: s.Nodes()}, nil
+}
+
+// Rejoin снимает пометку Leaving, если вывод ноды не удался
+func (s *Service) Rejoin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	me := s.members[s.self]
+	if me.Leaving {
+		me.Leaving = false
+		me.Incarnation++
+		s.enqueue(me.Member)
+	}
+	return nil
+}
+
+// Nodes - живые и подозреваемые ноды, включая эту
+func (s *Service) Nodes() []cluster.NodeInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodes := make([]cluster.NodeInfo, 0, len(s.members))
+	for _, m := range s.members {
+		if m.live() {
+			nodes = append(nodes, cluster.NodeInfo{ID: m.ID, Addr: m.Addr, Leaving: m.Leaving})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+func (m *memberState) live() bool {
+	return m.State == StateAlive || m.State == StateSuspect
+}
+
+// HandlePing отвечает на прямую проверку
+func (s *Service) HandlePing(req PingRequest) PingResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.merge(req.From)
+	s.mergeAll(req.Updates)
+	return PingResponse{Updates: s.reply(req.From.ID)}
+}
+
+// reply - обновления для ответа ноде from. Если она считается мертвой,
+// ей сообщают об этом, чтобы она опровергла это, даже если слух уже не рассылается.
+func (s *Service) reply(from string) []Member {
+	updates := s.piggyback()
+	if m, ok := s.members[from]; ok && !m.live() {
+		updates = append(updates, m.Member)
+	}
+	return updates
+}
+
+// HandleIndirectPing проверяет Target по просьбе другой ноды
+func (s *Service) HandleIndirectPing(ctx context.Context, req IndirectPingRequest) IndirectPingResponse {
+	s.mu.Lock()
+	s.merge(req.From)
+	s.mergeAll(req.Updates)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProbeTimeout)
+	defer cancel()
+	resp, err := s.transport.Ping(ctx, req.Target.Addr, s.pingRequest())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.mergeAll(resp.Updates)
+	}
+	return IndirectPingResponse{Ack: err == nil, Updates: s.reply(req.From.ID)}
+}
+
+// HandleSync принимает состав другой ноды и отвечает своим
+func (s *Service) HandleSync(req SyncRequest) SyncResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mergeAll(req.Members)
+	return SyncResponse{Members: s.snapshot()}
+}
+
+func (s *Service) probe() {
+	target, ok := s.nextTarget()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.ProbeTimeout)
+	resp, err := s.transport.Ping(ctx, target.Addr, s.pingRequest())
+	cancel()
+	if err == nil {
+		s.mu.Lock()
+		s.mergeAll(resp.Updates)
+		s.mu.Unlock()
+		return
+	}
+	if s.ctx.Err() != nil || s.indirectProbe(target) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.members[target.ID]; ok && cur.State == StateAlive && cur.Incarnation == target.Incarnation {
+		suspect := cur.Member
+		suspect.State = StateSuspect
+		s.merge(suspect)
+	}
+}
+
+// indirectProbe просит IndirectChecks случайных нод проверить target.
+// true - хотя бы одна из них получила ответ.
+func (s *Service) indirectProbe(target Member) bool {
+	s.mu.Lock()
+	helpers := s.pickMembers(s.cfg.IndirectChecks, target.ID)
+	s.mu.Unlock()
+	if len(helpers) == 0 {
+		return false
+	}
+
+	timeout := s.cfg.ProbeInterval - s.cfg.ProbeTimeout
+	if timeout < s.cfg.ProbeTimeout {
+		timeout = s.cfg.ProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	acks := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		req := IndirectPingRequest{Target: target}
+		ping := s.pingRequest()
+		req.From, req.Updates = ping.From, ping.Updates
+		go func(addr string) {
+			resp, err := s.transport.IndirectPing(ctx, addr, req)
+			if err == nil {
+				s.mu.Lock()
+				s.mergeAll(resp.Updates)
+				s.mu.Unlock()
+			}
+			acks <- err == nil && resp.Ack
+		}(h.Addr)
+	}
+	for range helpers {
+		if <-acks {
+			return true
+		}
+	}
+	return false
+}
+
+// reap объявляет мертвыми ноды, не опровергшие подозрение, и забывает давно мертвые
+func (s *Service) reap() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, m := range s.members {
+		switch {
+		case m.State == StateSuspect && now.Sub(m.changedAt) > s.cfg.SuspicionTimeout:
+			dead := m.Member
+			dead.State = StateDead
+			s.merge(dead)
+		case !m.live() && id != s.self && now.Sub(m.changedAt) > deadRetention:
+			delete(s.members, id)
+			delete(s.queue, id)
+		}
+	}
+}
+
+func (s *Service) sync(addr string) error {
+	s.mu.Lock()
+	req := SyncRequest{Members: s.snapshot()}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.ProbeInterval)
+	defer cancel()
+	resp, err := s.transport.Sync(ctx, addr, req)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mergeAll(resp.Members)
+	return nil
+}
+
+func (s *Service) pingRequest() PingRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PingRequest{From: s.members[s.self].Member, Updates: s.piggyback()}
+}
+
+// nextTarget - следующая нода для проверки. Ноды проверяются по кругу
+// в случайном порядке, который меняется на каждом круге.
+func (s *Service) nextTarget() (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		for s.probeNext < len(s.probeOrder) {
+			id := s.probeOrder[s.probeNext]
+			s.probeNext++
+			if m, ok := s.members[id]; ok && m.live() {
+				return m.Member, true
+			}
+		}
+		s.probeOrder = s.probeOrder[:0]
+		for id, m := range s.members {
+			if id != s.self && m.live() {
+				s.probeOrder = append(s.probeOrder, id)
+			}
+		}
+		rand.Shuffle(len(s.probeOrder), func(i, j int) {
+			s.probeOrder[i], s.probeOrder[j] = s.probeOrder[j], s.probeOrder[i]
+		})
+		s.probeNext = 0
+	}
+	return Member{}, false
+}
+
+func (s *Service) randomMember() (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	picked := s.pickMembers(1, "")
+	if len(picked) == 0 {
+		return Member{}, false
+	}
+	return picked[0], true
+}
+
+// pickMembers выбирает до n случайных живых нод, кроме этой и exclude
+func (s *Service) pickMembers(n int, exclude string) []Member {
+	var candidates []Member
+	for id, m := range s.members {
+		if id != s.self && id != exclude && m.State == StateAlive {
+			candidates = append(candidates, m.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+func (s *Service) snapshot() []Member {
+	out := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		out = append(out, m.Member)
+	}
+	return out
+}
+
+func (s *Service) mergeAll(updates []Member) {
+	for _, m := range updates {
+		s.merge(m)
+	}
+}
+
+// merge применяет обновление о ноде, если оно новее известного, и рассылает
+// его дальше. Обновление о себе, кроме самого свежего, нода опровергает,
+// повышая свою Incarnation.
+func (s *Service) merge(m Member) {
+	if m.ID == "" {
+		return
+	}
+	if m.ID == s.self {
+		s.refute(m)
+		return
+	}
+
+	cur, known := s.members[m.ID]
+	if known {
+		if m.Incarnation < cur.Incarnation {
+			return
+		}
+		if m.Incarnation == cur.Incarnation && rank(m.State) <= rank(cur.State) {
+			return
+		}
+		if m.State == StateSuspect && !cur.live() {
+			// Подозрение не возвращает мертвую ноду в состав
+			return
+		}
+	} else if !(m.State == StateAlive || m.State == StateDead || m.State == StateLeft) {
+		return
+	}
+
+	switch {
+	case !known && m.State == StateAlive:
+		log.Printf("[Gossip] Node %s (%s) joined", m.ID, m.Addr)
+	case m.State == StateAlive && !cur.live():
+		log.Printf("[Gossip] Node %s (%s) is back", m.ID, m.Addr)
+	case m.State == StateAlive && cur.State == StateSuspect:
+		log.Printf("[Gossip] Node %s refuted suspicion", m.ID)
+	case m.State == StateSuspect:
+		log.Printf("[Gossip] Node %s is suspected", m.ID)
+	case m.State == StateDead && known && cur.live():
+		log.Printf("[Gossip] Node %s is dead", m.ID)
+	case m.State == StateLeft && known && cur.live():
+		log.Printf("[Gossip] Node %s left", m.ID)
+	case known && m.Leaving && !cur.Leaving:
+		log.Printf("[Gossip] Node %s is leaving the cluster", m.ID)
+	}
+
+	st := &memberState{Member: m, changedAt: time.Now()}
+	if known && cur.State == m.State {
+		st.changedAt = cur.changedAt
+	}
+	s.members[m.ID] = st
+	s.enqueue(m)
+}
+
+func (s *Service) refute(m Member) {
+	me := s.members[s.self]
+	if me.State == StateLeft || m.Incarnation < me.Incarnation {
+		return
+	}
+	if m == me.Member {
+		return
+	}
+	me.Incarnation = m.Incarnation + 1
+	if m.State != StateAlive {
+		log.Printf("[Gossip] Refuting %s state, incarnation %d", m.State, me.Incarnation)
+	}
+	s.enqueue(me.Member)
+}
+
+// rank - сила состояния при равной Incarnation
+func rank(st State) int {
+	switch st {
+	case StateAlive:
+		return 0
+	case StateSuspect:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (s *Service) enqueue(m Member) {
+	s.queue[m.ID] = &broadcast{member: m}
+}
+
+// piggyback выбирает обновления для очередного сообщения: сначала те,
+// что передавались меньше всего. Обновление перестает рассылаться после
+// retransmitMult*log10(n+1) передач.
+func (s *Service) piggyback() []Member {
+	if len(s.queue) == 0 {
+		return nil
+	}
+	pending := make([]*broadcast, 0, len(s.queue))
+	for _, b := range s.queue {
+		pending = append(pending, b)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].transmits < pending[j].transmits })
+	if len(pending) > maxPiggyback {
+		pending = pending[:maxPiggyback]
+	}
+
+	limit := retransmitMult * int(math.Ceil(math.Log10(float64(len(s.members)+1))))
+	out := make([]Member, 0, len(pending))
+	for _, b := range pending {
+		out = append(out, b.member)
+		b.transmits++
+		if b.transmits >= limit {
+			delete(s.queue, b.member.ID)
+		}
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kv-store/internal/cluster"
+)
+
+// nopTransport - сеть, в которой никто не отвечает
+type nopTransport struct{}
+
+var errUnreachable = errors.New("unreachable")
+
+func (nopTransport) Ping(context.Context, string, PingRequest) (PingResponse, error) {
+	return PingResponse{}, errUnreachable
+}
+
+func (nopTransport) IndirectPing(context.Context, string, IndirectPingRequest) (IndirectPingResponse, error) {
+	return IndirectPingResponse{}, errUnreachable
+}
+
+func (nopTransport) Sync(context.Context, string, SyncRequest) (SyncResponse, error) {
+	return SyncResponse{}, errUnreachable
+}
+
+func newTestService() *Service {
+	return NewService(Config{
+		Self:             cluster.NodeInfo{ID: "a", Addr: "a:8080"},
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     500 * time.Millisecond,
+		IndirectChecks:   3,
+		SuspicionTimeout: 5 * time.Second,
+		SyncInterval:     10 * time.Second,
+	}, nopTransport{})
+}
+
+func (s *Service) member(id string) (Member, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[id]
+	if !ok {
+		return Member{}, false
+	}
+	return m.Member, true
+}
+
+func nodeIDs(nodes []cluster.NodeInfo) []string {
+	ids := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestMergeUnknownDepartedLeavingMember(t *testing.T) {
+	// Выведенная нода (left + leaving), которую эта нода уже забыла
+	// или никогда не знала, не должна ронять merge
+	for _, st := range []State{StateLeft, StateDead} {
+		s := newTestService()
+		s.HandleSync(SyncRequest{Members: []Member{
+			{ID: "b", Addr: "b:8080", State: st, Incarnation: 3, Leaving: true},
+		}})
+
+		m, ok := s.member("b")
+		if !ok || m.State != st {
+			t.Fatalf("%s: member b = %+v, %v", st, m, ok)
+		}
+		if ids := nodeIDs(s.Nodes()); len(ids) != 1 || ids[0] != "a" {
+			t.Fatalf("%s: nodes = %v, want [a]", st, ids)
+		}
+	}
+}
+
+func TestMergeLeavingKnownMember(t *testing.T) {
+	s := newTestService()
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateAlive, Incarnation: 1}}})
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateAlive, Incarnation: 2, Leaving: true}}})
+
+	nodes := s.Nodes()
+	if len(nodes) != 2 || nodes[1].ID != "b" || !nodes[1].Leaving {
+		t.Fatalf("nodes = %+v, want b leaving", nodes)
+	}
+}
+
+func TestMergeIgnoresStaleUpdates(t *testing.T) {
+	s := newTestService()
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateAlive, Incarnation: 5}}})
+
+	// Более старая Incarnation не меняет запись, даже если состояние сильнее
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateDead, Incarnation: 4}}})
+	if m, _ := s.member("b"); m.State != StateAlive || m.Incarnation != 5 {
+		t.Fatalf("member b = %+v, want alive/5", m)
+	}
+
+	// При равной Incarnation побеждает более сильное состояние
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateSuspect, Incarnation: 5}}})
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateAlive, Incarnation: 5}}})
+	if m, _ := s.member("b"); m.State != StateSuspect {
+		t.Fatalf("member b = %+v, want suspect", m)
+	}
+}
+
+func TestMergeSuspicionDoesNotRevive(t *testing.T) {
+	s := newTestService()
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateDead, Incarnation: 1}}})
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "b", Addr: "b:8080", State: StateSuspect, Incarnation: 2}}})
+	if m, _ := s.member("b"); m.State != StateDead {
+		t.Fatalf("member b = %+v, want dead", m)
+	}
+
+	// Подозрение о неизвестной ноде не добавляет ее в состав
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "c", Addr: "c:8080", State: StateSuspect, Incarnation: 1}}})
+	if _, ok := s.member("c"); ok {
+		t.Fatal("suspected unknown member c was added")
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	s := newTestService()
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "a", Addr: "a:8080", State: StateSuspect, Incarnation: 0}}})
+
+	me, _ := s.member("a")
+	if me.State != StateAlive || me.Incarnation != 1 {
+		t.Fatalf("self = %+v, want alive/1", me)
+	}
+}
+
+func TestStopAnnouncesLeft(t *testing.T) {
+	s := newTestService()
+	go s.Start(make(chan cluster.Topology, 1))
+	s.Stop()
+
+	me, _ := s.member("a")
+	if me.State != StateLeft {
+		t.Fatalf("self = %+v, want left", me)
+	}
+	// Ушедшая нода не опровергает слухи о себе
+	s.HandleSync(SyncRequest{Members: []Member{{ID: "a", Addr: "a:8080", State: StateDead, Incarnation: me.Incarnation}}})
+	if got, _ := s.member("a"); got.State != StateLeft || got.Incarnation != me.Incarnation {
+		t.Fatalf("self = %+v after rumor, want %+v", got, me)
+	}
+}
@@ -0,0 +1,66 @@
+package gossip
+
+import "context"
+
+// State - состояние участника с точки зрения группы
+type State string
+
+const (
+	StateAlive   State = "alive"
+	StateSuspect State = "suspect"
+	StateDead    State = "dead"
+	// StateLeft - нода ушла сама (остановилась); в отличие от dead, ее не подозревали
+	StateLeft State = "left"
+)
+
+// Member - запись о ноде. Ее же рассылают как обновление: более новая
+// запись о ноде имеет большую Incarnation, а при равной - более сильное
+// состояние (alive < suspect < dead). Повысить Incarnation может только
+// сама нода, опровергая подозрение.
+type Member struct {
+	ID          string `json:"id"`
+	Addr        string `json:"addr"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+	Leaving     bool   `json:"leaving,omitempty"`
+}
+
+// PingRequest - прямая проверка ноды. From и Updates передаются попутно,
+// чтобы обновления состава расходились без отдельных сообщений.
+type PingRequest struct {
+	From    Member   `json:"from"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type PingResponse struct {
+	Updates []Member `json:"updates,omitempty"`
+}
+
+// IndirectPingRequest просит ноду проверить Target от имени From
+type IndirectPingRequest struct {
+	From    Member   `json:"from"`
+	Target  Member   `json:"target"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type IndirectPingResponse struct {
+	Ack     bool     `json:"ack"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+// SyncRequest - обмен полным составом (push-pull): при входе в группу и
+// периодически, чтобы догнать пропущенные обновления
+type SyncRequest struct {
+	Members []Member `json:"members"`
+}
+
+type SyncResponse struct {
+	Members []Member `json:"members"`
+}
+
+// Transport доставляет сообщения другим нодам по адресу
+type Transport interface {
+	Ping(ctx context.Context, addr string, req PingRequest) (PingResponse, error)
+	IndirectPing(ctx context.Context, addr string, req IndirectPingRequest) (IndirectPingResponse, error)
+	Sync(ctx context.Context, addr string, req SyncRequest) (SyncResponse, error)
+}
@@ -0,0 +1,329 @@
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+	"kv-store/internal/wal"
+)
+
+const (
+	// replayInterval - как часто пытаться доставить подсказки, даже если кольцо не менялось
+	replayInterval = 5 * time.Second
+
+	// maxHintsPerNode ограничивает память под подсказки для одной недоступной ноды
+	maxHintsPerNode = 100000
+)
+
+// ErrTooManyHints - для ноды уже накоплено maxHintsPerNode подсказок, новая отброшена
+var ErrTooManyHints = errors.New("too many hints")
+
+// Hint - запись (значение или tombstone), которую не удалось доставить
+// ноде-владельцу. Хранится на координаторе, пока владелец снова не станет доступен.
+type Hint struct {
+	Key   string
+	Entry kv.Entry
+
+	seq uint64
+}
+
+// record - запись журнала подсказок: новая подсказка или (Done) ее доставка
+type record struct {
+	Target hashring.NodeID `json:"target"`
+	Key    string          `json:"key"`
+	Entry  kv.Entry        `json:"entry"`
+	Done   bool            `json:"done,omitempty"`
+}
+
+// Service хранит подсказки в памяти и в журнале (WAL), чтобы они
+// пережили перезапуск координатора
+type Service struct {
+	store *kv.Store
+	ring  *hashring.HashRing
+	peers *peer.Client
+
+	mu     sync.Mutex
+	hints  map[hashring.NodeID]map[string]Hint
+	seq    uint64
+	onFold func()
+	wal    *wal.Log // nil - подсказки только в памяти
+	// garbage - записи журнала, которые уже ничего не значат (доставленные
+	// и замененные подсказки); когда их больше живых, журнал переписывается
+	garbage int
+	// running держит цикл доставки, Stop ждет его завершения
+	running sync.Mutex
+
+	triggerCh chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewService открывает журнал подсказок в dir и восстанавливает недоставленные.
+// Пустой dir - подсказки только в памяти и теряются при перезапуске.
+func NewService(store *kv.Store, ring *hashring.HashRing, dir string) (*Service, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		store:     store,
+		ring:      ring,
+		peers:     peer.NewClient(5 * time.Second),
+		hints:     make(map[hashring.NodeID]map[string]Hint),
+		triggerCh: make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	journal, err := wal.Open(wal.Options{Dir: dir, Sync: wal.SyncAlways}, func(_ uint64, payload []byte) error {
+		var rec record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return fmt.Errorf("hint log: %w", err)
+		}
+		s.apply(rec)
+		return nil
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.wal = journal
+	if n := s.Pending(); n > 0 {
+		log.Printf("Recovered %d undelivered hints", n)
+	}
+	return s, nil
+}
+
+func (s *Service) Start() {
+	log.Println("Hinted handoff worker started")
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.triggerCh:
+			s.replay()
+		case <-ticker.C:
+			s.replay()
+		}
+	}
+}
+
+// Stop прерывает доставку подсказок, ждет завершения текущего цикла
+// и закрывает журнал
+func (s *Service) Stop() {
+	s.cancel()
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal != nil {
+		if err := s.wal.Close(); err != nil {
+			log.Printf("ERR: Failed to close hint log: %v", err)
+		}
+	}
+}
+
+func (s *Service) Trigger() {
+	select {
+	case s.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// OnFold задает callback, который вызывается после того, как подсказки ушедшей
+// из кольца ноды вернулись в локальное хранилище (обычно - запуск ребалансировки)
+func (s *Service) OnFold(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFold = fn
+}
+
+// Add сохраняет подсказку для ноды target. Более поздняя запись
+// того же ключа заменяет предыдущую. Ошибка - подсказка не сохранена.
+func (s *Service) Add(target hashring.NodeID, h Hint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.hints[target][h.Key]; !exists && len(s.hints[target]) >= maxHintsPerNode {
+		log.Printf("WARN: Too many hints for node %s, dropping key %s: the replica misses this write until read repair or rebalance", target, h.Key)
+		return ErrTooManyHints
+	}
+	if err := s.appendLocked(record{Target: target, Key: h.Key, Entry: h.Entry}); err != nil {
+		return err
+	}
+	s.apply(record{Target: target, Key: h.Key, Entry: h.Entry})
+	return nil
+}
+
+// Pending возвращает число недоставленных подсказок
+func (s *Service) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, byKey := range s.hints {
+		n += len(byKey)
+	}
+	return n
+}
+
+func (s *Service) replay() {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	s.mu.Lock()
+	pending := make(map[hashring.NodeID][]Hint, len(s.hints))
+	for target, byKey := range s.hints {
+		for _, h := range byKey {
+			pending[target] = append(pending[target], h)
+		}
+	}
+	onFold := s.onFold
+	s.mu.Unlock()
+
+	folded := 0
+	defer func() {
+		if err := s.compact(); err != nil {
+			log.Printf("ERR: Failed to compact hint log: %v", err)
+		}
+		if folded > 0 && onFold != nil {
+			onFold()
+		}
+	}()
+
+	for target, hints := range pending {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		addr, ok := s.ring.GetNodeAddr(target)
+		if !ok {
+			// Владелец ушел из кольца: возвращаем записи в локальное хранилище,
+			// дальше их разнесет по новым репликам ребалансировка.
+			n := 0
+			for _, h := range hints {
+				if _, err := s.store.PutEntry(h.Key, h.Entry); err != nil {
+					log.Printf("ERR: Failed to fold hint for key %s: %v", h.Key, err)
+					continue
+				}
+				s.remove(target, h)
+				n++
+			}
+			folded += n
+			log.Printf("Node %s left the ring, folded %d hints into local store", target, n)
+			continue
+		}
+
+		delivered := 0
+		for _, h := range hints {
+			if err := s.peers.Put(s.ctx, addr, h.Key, h.Entry); err != nil {
+				log.Printf("ERR: Hint replay to %s failed: %v", target, err)
+				break
+			}
+			s.remove(target, h)
+			delivered++
+		}
+		if delivered > 0 {
+			log.Printf("Replayed %d/%d hints to node %s", delivered, len(hints), target)
+		}
+	}
+}
+
+// remove удаляет доставленную подсказку, если за это время ее не заменили новой
+func (s *Service) remove(target hashring.NodeID, h Hint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cur, ok := s.hints[target][h.Key]; !ok || cur.seq != h.seq {
+		return
+	}
+	// Если отметка о доставке не записалась, после перезапуска запись
+	// доставится повторно, а реплика не перезапишет ту же версию
+	if err := s.appendLocked(record{Target: target, Key: h.Key, Done: true}); err != nil {
+		log.Printf("ERR: Failed to log delivered hint for key %s: %v", h.Key, err)
+	}
+	s.apply(record{Target: target, Key: h.Key, Done: true})
+}
+
+// apply применяет запись журнала к подсказкам в памяти. Вызывается под s.mu
+// или при открытии журнала.
+func (s *Service) apply(rec record) {
+	byKey, ok := s.hints[rec.Target]
+	if rec.Done {
+		if _, exists := byKey[rec.Key]; exists {
+			delete(byKey, rec.Key)
+			s.garbage += 2
+		}
+		if len(byKey) == 0 {
+			delete(s.hints, rec.Target)
+		}
+		return
+	}
+
+	if !ok {
+		byKey = make(map[string]Hint)
+		s.hints[rec.Target] = byKey
+	}
+	if _, exists := byKey[rec.Key]; exists {
+		s.garbage++
+	}
+	s.seq++
+	byKey[rec.Key] = Hint{Key: rec.Key, Entry: rec.Entry, seq: s.seq}
+}
+
+func (s *Service) appendLocked(rec record) error {
+	if s.wal == nil {
+		return nil
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.wal.Append(payload)
+	return err
+}
+
+// compact переписывает журнал, когда в нем больше отработавших записей,
+// чем недоставленных подсказок: живые подсказки переносятся в новый
+// сегмент, старые сегменты удаляются
+func (s *Service) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := 0
+	for _, byKey := range s.hints {
+		live += len(byKey)
+	}
+	if s.wal == nil || s.garbage <= live {
+		return nil
+	}
+
+	if err := s.wal.Roll(); err != nil {
+		return err
+	}
+	upTo := s.wal.LastSeq()
+	for target, byKey := range s.hints {
+		for _, h := range byKey {
+			if err := s.appendLocked(record{Target: target, Key: h.Key, Entry: h.Entry}); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := s.wal.TruncateBefore(upTo); err != nil {
+		return err
+	}
+	s.garbage = 0
+	return nil
+}
@@ -0,0 +1,100 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+)
+
+func newTestService(t *testing.T, dir string) (*Service, *kv.Store) {
+	t.Helper()
+	engine, err := kv.OpenEngine(kv.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := kv.NewStore(engine)
+	t.Cleanup(func() { store.Close() })
+
+	s, err := NewService(store, hashring.New(10), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, store
+}
+
+func hint(key, value string) Hint {
+	return Hint{Key: key, Entry: kv.Entry{Value: []byte(value), Version: kv.Version{Wall: 1, Node: "n1"}}}
+}
+
+func TestHintsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newTestService(t, dir)
+	for i := 0; i < 3; i++ {
+		if err := s.Add("n2", hint(fmt.Sprintf("k%d", i), "old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Add("n2", hint("k0", "new"))
+	s.Add("n3", hint("k1", "v"))
+	s.Stop()
+
+	s, _ = newTestService(t, dir)
+	defer s.Stop()
+	if n := s.Pending(); n != 4 {
+		t.Fatalf("recovered %d hints, want 4", n)
+	}
+	if h := s.hints["n2"]["k0"]; string(h.Entry.Value) != "new" || h.Entry.Version.Node != "n1" {
+		t.Fatalf("k0 recovered as %+v", h)
+	}
+}
+
+func TestDeliveredHintsAreNotRecovered(t *testing.T) {
+	dir := t.TempDir()
+	s, store := newTestService(t, dir)
+	for i := 0; i < 10; i++ {
+		s.Add("gone", hint(fmt.Sprintf("k%d", i), "v"))
+	}
+	// Нода ушла из кольца: подсказки возвращаются в локальное хранилище,
+	// после чего журнал переписывается
+	s.replay()
+	if n := s.Pending(); n != 0 {
+		t.Fatalf("%d hints left after fold", n)
+	}
+	if e, err := store.GetEntry("k3"); err != nil || string(e.Value) != "v" {
+		t.Fatalf("folded key: %q, %v", e.Value, err)
+	}
+	if s.garbage != 0 {
+		t.Fatalf("hint log not compacted, %d dead records", s.garbage)
+	}
+	s.Add("n2", hint("after", "v"))
+	s.Stop()
+
+	s, _ = newTestService(t, dir)
+	defer s.Stop()
+	if n := s.Pending(); n != 1 || s.hints["n2"]["after"].Key != "after" {
+		t.Fatalf("recovered %v", s.hints)
+	}
+}
+
+func TestTooManyHintsForNode(t *testing.T) {
+	s, _ := newTestService(t, "")
+	defer s.Stop()
+	for i := 0; i < maxHintsPerNode; i++ {
+		if err := s.Add("n2", hint(fmt.Sprintf("k%d", i), "v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add("n2", hint("extra", "v")); !errors.Is(err, ErrTooManyHints) {
+		t.Fatalf("Add over the limit: %v", err)
+	}
+	// Замена уже сохраненной подсказки и подсказки другим нодам проходят
+	if err := s.Add("n2", hint("k0", "new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("n3", hint("extra", "v")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -18,6 +18,10 @@ type HashRing struct {
 	hashToNode map[uint32]NodeID
 
 	nodes map[NodeID]string
+	// leaving - выводимые из кластера ноды: адрес известен, но позиций на кольце нет
+	leaving map[NodeID]bool
+	// epoch - эпоха состава, по которому построено кольцо
+	epoch uint64
 }
 
 func New(vnodes int) *HashRing {
@@ -26,6 +30,7 @@ func New(vnodes int) *HashRing {
 		ring:       []uint32{},
 		hashToNode: make(map[uint32]NodeID),
 		nodes:      make(map[NodeID]string),
+		leaving:    make(map[NodeID]bool),
 	}
 }
 
@@ -34,20 +39,30 @@ func hash(s string) uint32 {
 	return binary.BigEndian.Uint32(h[:4])
 }
 
-func (r *HashRing) UpdateRing(activeNodes []cluster.NodeInfo) {
+// UpdateRing пересобирает кольцо по списку активных нод. Выводимые ноды
+// (Leaving) остаются доступны по адресу, но ключей не получают. Состав
+// с эпохой меньше текущей (опоздавший ответ seed) не применяется.
+// Возвращает true, если набор нод изменился.
+func (r *HashRing) UpdateRing(t cluster.Topology) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	newSet := make(map[NodeID]string, len(activeNodes))
+	if t.Epoch != 0 && t.Epoch < r.epoch {
+		return false
+	}
+	r.epoch = t.Epoch
+	activeNodes := t.Nodes
+
+	newSet := make(map[NodeID]cluster.NodeInfo, len(activeNodes))
 	for _, info := range activeNodes {
-		newSet[NodeID(info.ID)] = info.Addr
+		newSet[NodeID(info.ID)] = info
 	}
 
 	changed := false
 
 	nodesToRemove := []NodeID{}
 	for id := range r.nodes {
-		if _, exists := newSet[id]; !exists {
+		if info, exists := newSet[id]; !exists || info.Leaving != r.leaving[id] {
 			nodesToRemove = append(nodesToRemove, id)
 		}
 	}
@@ -57,19 +72,24 @@ func (r *HashRing) UpdateRing(activeNodes []cluster.NodeInfo) {
 		changed = true
 	}
 
-	for id, addr := range newSet {
+	for id, info := range newSet {
 		oldAddr, exists := r.nodes[id]
-		if !exists {
-			r.addNode(id, addr)
+		switch {
+		case !exists && info.Leaving:
+			r.nodes[id] = info.Addr
+			r.leaving[id] = true
+		case !exists:
+			r.addNode(id, info.Addr)
 			changed = true
-		} else if oldAddr != addr {
-			r.nodes[id] = addr
+		case oldAddr != info.Addr:
+			r.nodes[id] = info.Addr
 		}
 	}
 
 	if changed {
 		sort.Slice(r.ring, func(i, j int) bool { return r.ring[i] < r.ring[j] })
 	}
+	return changed
 }
 
 func (r *HashRing) removeNodes(idsToRemove []NodeID) {
@@ -77,6 +97,7 @@ func (r *HashRing) removeNodes(idsToRemove []NodeID) {
 	for _, id := range idsToRemove {
 		toRemoveSet[id] = struct{}{}
 		delete(r.nodes, id)
+		delete(r.leaving, id)
 	}
 
 	newRing := make([]uint32, 0, len(r.ring))
@@ -107,6 +128,13 @@ func (r *HashRing) addNode(id NodeID, addr string) {
 	}
 }
 
+// Epoch - эпоха состава, по которому построено кольцо; 0 - неизвестна
+func (r *HashRing) Epoch() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.epoch
+}
+
 func (r *HashRing) GetNodeAddr(id NodeID) (string, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -114,6 +142,26 @@ func (r *HashRing) GetNodeAddr(id NodeID) (string, bool) {
 	return addr, ok
 }
 
+// Nodes возвращает id всех физических нод кольца в отсортированном порядке,
+// включая выводимые: у них еще могут быть не перенесенные ключи
+func (r *HashRing) Nodes() []NodeID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]NodeID, 0, len(r.nodes))
+	for id := range r.nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Size возвращает число нод, владеющих ключами (без выводимых)
+func (r *HashRing) Size() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes) - len(r.leaving)
+}
+
 func (r *HashRing) PrimaryNode(key string) (NodeID, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -127,3 +175,45 @@ func (r *HashRing) PrimaryNode(key string) (NodeID, error) {
 	}
 	return r.hashToNode[r.ring[i]], nil
 }
+
+// ReplicaNodes возвращает до n различных физических нод, начиная с владельца
+// ключа и далее по часовой стрелке. Первая нода в списке совпадает с PrimaryNode.
+func (r *HashRing) ReplicaNodes(key string, n int) ([]NodeID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.ring) == 0 {
+		return nil, fmt.Errorf("no nodes")
+	}
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(r.nodes)-len(r.leaving) {
+		n = len(r.nodes) - len(r.leaving)
+	}
+
+	h := hash(key)
+	start := sort.Search(len(r.ring), func(i int) bool { return r.ring[i] >= h })
+
+	replicas := make([]NodeID, 0, n)
+	seen := make(map[NodeID]struct{}, n)
+	for i := 0; i < len(r.ring) && len(replicas) < n; i++ {
+		id := r.hashToNode[r.ring[(start+i)%len(r.ring)]]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		replicas = append(replicas, id)
+	}
+	return replicas, nil
+}
+
+// Shard возвращает номер шарда ключа при n шардах (режим consistency: raft)
+func Shard(key string, n int) int {
+	return int(hash(key) % uint32(n))
+}
+
+// ShardNodes возвращает до n нод группы шарда: как реплики ключа,
+// начиная с позиции шарда на кольце
+func (r *HashRing) ShardNodes(shard, n int) ([]NodeID, error) {
+	return r.ReplicaNodes(fmt.Sprintf("shard-%d", shard), n)
+}
@@ -0,0 +1,31 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// OnDecommission задает функцию, которая начинает вывод ноды из кластера
+func (h *Handler) OnDecommission(fn func() error) {
+	h.decommission = fn
+}
+
+// Decommission выводит ноду из кластера: она перестает владеть ключами,
+// переносит данные новым владельцам и завершается. Отвечает сразу после
+// начала вывода, перенос данных идет в фоне (см. логи ноды).
+func (h *Handler) Decommission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.decommission == nil {
+		http.Error(w, "decommission is not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := h.decommission(); err != nil {
+		http.Error(w, fmt.Sprintf("decommission failed: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("decommissioning"))
+}
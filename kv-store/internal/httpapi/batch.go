@@ -0,0 +1,280 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+)
+
+const (
+	// maxBatchKeys ограничивает размер одного /mget или /mput
+	maxBatchKeys = 1000
+
+	// batchParallelism - сколько ключей пакета обрабатывается одновременно
+	batchParallelism = 32
+)
+
+type mgetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type mputRequest struct {
+	Items []mputItem `json:"items"`
+}
+
+type mputItem struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// batchResult - результат по одному ключу; Status - HTTP-код, который
+// вернул бы одиночный /get или /put
+type batchResult struct {
+	Key     string `json:"key"`
+	Status  int    `json:"status"`
+	Value   []byte `json:"value,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// MGet читает несколько ключей за один запрос.
+// Ключи группируются по владельцу, каждой группе уходит один подзапрос.
+func (h *Handler) MGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req mgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) > maxBatchKeys {
+		http.Error(w, fmt.Sprintf("too many keys (max %d)", maxBatchKeys), http.StatusBadRequest)
+		return
+	}
+
+	need := h.cfg.ReadQuorum
+	results := h.runBatch(w, r, "/mget", len(req.Keys),
+		func(i int) string { return req.Keys[i] },
+		func(idx []int) interface{} {
+			sub := mgetRequest{Keys: make([]string, len(idx))}
+			for j, i := range idx {
+				sub.Keys[j] = req.Keys[i]
+			}
+			return sub
+		},
+		func(i int) batchResult {
+			key := req.Keys[i]
+			replicas, res, ok := h.batchReplicas(key)
+			if !ok {
+				return res
+			}
+			q, err := h.quorumParam(r, "r", need, len(replicas))
+			if err != nil {
+				return batchResult{Key: key, Status: http.StatusBadRequest, Error: err.Error()}
+			}
+
+			latest, found, err := h.readKey(key, replicas, q)
+			if err != nil {
+				return batchResult{Key: key, Status: http.StatusServiceUnavailable, Error: err.Error()}
+			}
+			if !found {
+				return batchResult{Key: key, Status: http.StatusNotFound}
+			}
+			return batchResult{Key: key, Status: http.StatusOK, Value: latest.Value, Version: latest.Version.String()}
+		},
+	)
+	writeBatch(w, results)
+}
+
+// MPut записывает несколько ключей за один запрос; запись каждого ключа
+// независима, общей атомарности у пакета нет
+func (h *Handler) MPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req mputRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) > maxBatchKeys {
+		http.Error(w, fmt.Sprintf("too many keys (max %d)", maxBatchKeys), http.StatusBadRequest)
+		return
+	}
+
+	need := h.cfg.WriteQuorum
+	results := h.runBatch(w, r, "/mput", len(req.Items),
+		func(i int) string { return req.Items[i].Key },
+		func(idx []int) interface{} {
+			sub := mputRequest{Items: make([]mputItem, len(idx))}
+			for j, i := range idx {
+				sub.Items[j] = req.Items[i]
+			}
+			return sub
+		},
+		func(i int) batchResult {
+			item := req.Items[i]
+			replicas, res, ok := h.batchReplicas(item.Key)
+			if !ok {
+				return res
+			}
+			q, err := h.quorumParam(r, "w", need, len(replicas))
+			if err != nil {
+				return batchResult{Key: item.Key, Status: http.StatusBadRequest, Error: err.Error()}
+			}
+			ttl, err := parseTTLValue(item.TTL)
+			if err != nil {
+				return batchResult{Key: item.Key, Status: http.StatusBadRequest, Error: err.Error()}
+			}
+
+			entry := kv.Entry{Value: item.Value, Version: h.clock.Now(), ExpiresAt: expiresAt(ttl)}
+			if err := h.writeUnlocked(item.Key, replicas, q, entry); err != nil {
+				status := http.StatusServiceUnavailable
+				if errors.Is(err, kv.ErrLocked) {
+					status = http.StatusConflict
+				}
+				return batchResult{Key: item.Key, Status: status, Error: err.Error()}
+			}
+			return batchResult{Key: item.Key, Status: http.StatusNoContent, Version: entry.Version.String()}
+		},
+	)
+	writeBatch(w, results)
+}
+
+// batchReplicas возвращает реплики ключа или готовый результат с ошибкой
+func (h *Handler) batchReplicas(key string) ([]hashring.NodeID, batchResult, bool) {
+	if key == "" {
+		return nil, batchResult{Status: http.StatusBadRequest, Error: "key required"}, false
+	}
+	replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
+	if err != nil {
+		return nil, batchResult{Key: key, Status: http.StatusServiceUnavailable, Error: "no nodes"}, false
+	}
+	return replicas, batchResult{}, true
+}
+
+// runBatch раскладывает n ключей пакета по владельцам. Свои ключи нода
+// обрабатывает сама через local, остальным владельцам параллельно уходит
+// по одному подзапросу на path с ключами из subset. Если владелец недоступен,
+// его ключи координирует текущая нода. Результаты идут в порядке запроса.
+func (h *Handler) runBatch(
+	w http.ResponseWriter,
+	r *http.Request,
+	path string,
+	n int,
+	keyAt func(i int) string,
+	subset func(idx []int) interface{},
+	local func(i int) batchResult,
+) []batchResult {
+	results := make([]batchResult, n)
+
+	// Подзапрос от другой ноды уже сгруппирован: обрабатываем целиком,
+	// если только отправитель не группировал по более старому составу
+	route := r.Header.Get(forwardedHeader) == "" || h.staleForward(w, r)
+	groups := map[hashring.NodeID][]int{}
+	for i := 0; i < n; i++ {
+		owner := h.self
+		if route {
+			if id, err := h.owner(r.Context(), keyAt(i)); err == nil {
+				owner = id
+			}
+		}
+		groups[owner] = append(groups[owner], i)
+	}
+
+	var wg sync.WaitGroup
+	for owner, idx := range groups {
+		wg.Add(1)
+		go func(owner hashring.NodeID, idx []int) {
+			defer wg.Done()
+			if owner != h.self {
+				sub, err := h.forwardBatch(r, path, owner, subset(idx))
+				if err == nil && len(sub) == len(idx) {
+					for j, i := range idx {
+						results[i] = sub[j]
+					}
+					return
+				}
+				log.Printf("ERR: Batch forward to %s failed (%v), coordinating %d keys locally", owner, err, len(idx))
+			}
+			h.runLocal(idx, local, results)
+		}(owner, idx)
+	}
+	wg.Wait()
+	return results
+}
+
+// runLocal обрабатывает ключи не более чем по batchParallelism одновременно
+func (h *Handler) runLocal(idx []int, local func(i int) batchResult, results []batchResult) {
+	sem := make(chan struct{}, batchParallelism)
+	var wg sync.WaitGroup
+	for _, i := range idx {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = local(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// forwardBatch отправляет подпакет владельцу ключей с теми же query-параметрами
+func (h *Handler) forwardBatch(r *http.Request, path string, owner hashring.NodeID, body interface{}) ([]batchResult, error) {
+	addr, ok := h.ring.GetNodeAddr(owner)
+	if !ok {
+		return nil, fmt.Errorf("node address not found: %s", owner)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("http://%s%s?%s", addr, path, r.URL.RawQuery)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forwardedHeader, string(h.self))
+	h.tagEpoch(req.Header)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	h.observeEpoch(resp.Header)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var out batchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Results, nil
+}
+
+func writeBatch(w http.ResponseWriter, results []batchResult) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(batchResponse{Results: results})
+}
@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+)
+
+// forwardedHeader помечает запрос, уже переданный координатором владельцу ключа,
+// чтобы при расхождении колец ноды не пересылали его друг другу по кругу
+const forwardedHeader = "X-KV-Forwarded"
+
+// parseCondition разбирает заголовки If-Match / If-None-Match
+func parseCondition(r *http.Request) (kv.Condition, error) {
+	var cond kv.Condition
+
+	if raw := r.Header.Get("If-Match"); raw != "" {
+		raw = strings.Trim(strings.TrimSpace(raw), `"`)
+		if raw == "*" {
+			cond.Exists = true
+		} else {
+			v, err := kv.ParseVersion(raw)
+			if err != nil {
+				return kv.Condition{}, fmt.Errorf("bad If-Match: %w", err)
+			}
+			cond.Version = v
+		}
+	}
+
+	if raw := r.Header.Get("If-None-Match"); raw != "" {
+		if strings.TrimSpace(raw) != "*" {
+			return kv.Condition{}, errors.New(`only "If-None-Match: *" is supported`)
+		}
+		cond.NotExists = true
+	}
+
+	if cond.NotExists && (cond.Exists || !cond.Version.IsZero()) {
+		return kv.Condition{}, errors.New("If-Match and If-None-Match are mutually exclusive")
+	}
+	return cond, nil
+}
+
+// forwardToPrimary отправляет запрос владельцу ключа, если это не текущая нода.
+// Возвращает true, если запрос обработан (проксирован или завершился ошибкой).
+func (h *Handler) forwardToPrimary(w http.ResponseWriter, r *http.Request, replicas []hashring.NodeID) bool {
+	primary := replicas[0]
+	if r.Header.Get(forwardedHeader) != "" && !h.staleForward(w, r) {
+		return false
+	}
+	if primary == h.self {
+		return false
+	}
+
+	r.Header.Set(forwardedHeader, string(h.self))
+	if err := h.proxyRequest(w, r, primary); err != nil {
+		http.Error(w, fmt.Sprintf("primary %s unavailable: %v", primary, err), http.StatusBadGateway)
+	}
+	return true
+}
+
+// forwardWrite отправляет запись без условий владельцу ключа: только он
+// знает о блокировках транзакций. body - уже прочитанное тело запроса.
+// Если владелец недоступен, запись координирует текущая нода (как и ключи
+// недоступного владельца в /mput) - блокировки тогда не проверяются.
+// Возвращает true, если запрос обработан владельцем.
+func (h *Handler) forwardWrite(w http.ResponseWriter, r *http.Request, key string, replicas []hashring.NodeID, body []byte) bool {
+	primary := replicas[0]
+	if r.Header.Get(forwardedHeader) != "" && !h.staleForward(w, r) {
+		return false
+	}
+	if primary == h.self {
+		return false
+	}
+
+	r.Body = http.NoBody
+	if len(body) > 0 {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	r.Header.Set(forwardedHeader, string(h.self))
+	if err := h.proxyRequest(w, r, primary); err != nil {
+		log.Printf("ERR: Primary %s unavailable (%v), coordinating write of %s locally", primary, err, key)
+		return false
+	}
+	return true
+}
+
+// syncFromReplicas подтягивает на владельца самую новую версию ключа
+// среди реплик, чтобы условие проверялось не по отставшей копии
+func (h *Handler) syncFromReplicas(key string, replicas []hashring.NodeID) {
+	need := h.cfg.ReadQuorum
+	if need > len(replicas) {
+		need = len(replicas)
+	}
+
+	call, _ := h.quorum(replicas, need,
+		func() (kv.Entry, error) { return h.store.GetEntry(key) },
+		func(ctx context.Context, _ hashring.NodeID, addr string) (kv.Entry, error) {
+			return h.peers.Get(ctx, addr, key)
+		},
+	)
+	if latest, found := newest(call.acked); found {
+		h.clock.Observe(latest.Version)
+		if _, err := h.store.PutEntry(key, latest); err != nil {
+			log.Printf("ERR: Failed to sync %s from replicas: %v", key, err)
+		}
+	}
+}
+
+// writeConditional атомарно применяет запись на владельце ключа
+// и затем реплицирует ее на остальные реплики
+func (h *Handler) writeConditional(w http.ResponseWriter, key string, replicas []hashring.NodeID, need int, cond kv.Condition, entry kv.Entry) {
+	h.syncFromReplicas(key, replicas)
+
+	entry.Version = h.clock.Now()
+	cur, err := h.store.CompareAndPut(key, cond, entry)
+	if errors.Is(err, kv.ErrPreconditionFailed) {
+		if !cur.Version.IsZero() && !cur.Deleted && !cur.Expired(time.Now()) {
+			w.Header().Set(peer.VersionHeader, cur.Version.String())
+		}
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, kv.ErrLocked) {
+		http.Error(w, "key is locked by a transaction", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERR: Conditional write of %s failed: %v", key, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.writeKey(key, replicas, need, entry); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set(peer.VersionHeader, entry.Version.String())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+)
+
+// incrAttempts - сколько раз повторить инкремент, если локальная версия
+// ключа оказалась новее часов ноды
+const incrAttempts = 3
+
+// Incr атомарно прибавляет delta (по умолчанию 1) к целому значению ключа
+func (h *Handler) Incr(w http.ResponseWriter, r *http.Request) {
+	h.incr(w, r, 1)
+}
+
+// Decr атомарно вычитает delta (по умолчанию 1) из целого значения ключа
+func (h *Handler) Decr(w http.ResponseWriter, r *http.Request) {
+	h.incr(w, r, -1)
+}
+
+// incr, как и условная запись, выполняется только на владельце ключа:
+// он подтягивает самую новую версию с реплик, атомарно меняет значение
+// в kv.Store и реплицирует результат
+func (h *Handler) incr(w http.ResponseWriter, r *http.Request, sign int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+
+	delta := int64(1)
+	if raw := r.URL.Query().Get("delta"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v == math.MinInt64 {
+			http.Error(w, "invalid delta", http.StatusBadRequest)
+			return
+		}
+		delta = v
+	}
+	delta *= sign
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.raft != nil {
+		h.consensusWrite(w, r, kv.Command{Op: kv.OpIncr, Key: key, Delta: delta, ExpiresAt: expiresAt(ttl)})
+		return
+	}
+
+	replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
+	if err != nil {
+		http.Error(w, "no nodes", http.StatusServiceUnavailable)
+		return
+	}
+
+	need, err := h.quorumParam(r, "w", h.cfg.WriteQuorum, len(replicas))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.forwardToPrimary(w, r, replicas) {
+		return
+	}
+
+	h.syncFromReplicas(key, replicas)
+
+	var (
+		entry kv.Entry
+		n     int64
+	)
+	for attempt := 0; ; attempt++ {
+		var cur kv.Entry
+		cur, n, err = h.store.Incr(key, delta, h.clock.Now(), expiresAt(ttl))
+		if !errors.Is(err, kv.ErrPreconditionFailed) || attempt+1 == incrAttempts {
+			entry = cur
+			break
+		}
+		h.clock.Observe(cur.Version)
+	}
+
+	switch {
+	case errors.Is(err, kv.ErrNotInteger), errors.Is(err, kv.ErrOverflow):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, kv.ErrLocked):
+		http.Error(w, "key is locked by a transaction", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("ERR: Increment of %s failed: %v", key, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.writeKey(key, replicas, need, entry); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set(peer.VersionHeader, entry.Version.String())
+	_, _ = fmt.Fprintf(w, "%d", n)
+}
@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kv-store/internal/gossip"
+)
+
+// Сообщения gossip-группы (cluster.membership: gossip)
+
+func (h *Handler) InternalGossipPing(w http.ResponseWriter, r *http.Request) {
+	var req gossip.PingRequest
+	if !h.decodeGossip(w, r, &req) {
+		return
+	}
+	writeGossip(w, h.gossip.HandlePing(req))
+}
+
+func (h *Handler) InternalGossipIndirectPing(w http.ResponseWriter, r *http.Request) {
+	var req gossip.IndirectPingRequest
+	if !h.decodeGossip(w, r, &req) {
+		return
+	}
+	writeGossip(w, h.gossip.HandleIndirectPing(r.Context(), req))
+}
+
+func (h *Handler) InternalGossipSync(w http.ResponseWriter, r *http.Request) {
+	var req gossip.SyncRequest
+	if !h.decodeGossip(w, r, &req) {
+		return
+	}
+	writeGossip(w, h.gossip.HandleSync(req))
+}
+
+func (h *Handler) decodeGossip(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if h.gossip == nil {
+		http.Error(w, "gossip membership is disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeGossip(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -1,39 +1,71 @@
 package httpapi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"kv-store/internal/config"
+	"kv-store/internal/gossip"
+	"kv-store/internal/handoff"
 	"kv-store/internal/hashring"
 	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+	"kv-store/internal/raft"
+	"kv-store/internal/txn"
 )
 
 type Handler struct {
 	store  *kv.Store
 	ring   *hashring.HashRing
 	self   hashring.NodeID
+	cfg    config.HashConfig
 	client *http.Client
+	peers  *peer.Client
+	hints  *handoff.Service
+	clock  *kv.Clock
+	txns   txnLog
+	raft   *raft.Host      // nil - режим eventual
+	gossip *gossip.Service // nil - состав кластера берется у seed
+
+	decommission    func() error
+	refreshTopology func()
+
+	// closing закрывается при остановке ноды, чтобы завершить потоки /watch
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
-func NewHandler(store *kv.Store, ring *hashring.HashRing, self hashring.NodeID) *Handler {
+func NewHandler(store *kv.Store, ring *hashring.HashRing, self hashring.NodeID, cfg config.HashConfig, hints *handoff.Service, txns *txn.Log, consensus *raft.Host, members *gossip.Service) *Handler {
 	return &Handler{
 		store:  store,
 		ring:   ring,
 		self:   self,
+		cfg:    cfg,
 		client: &http.Client{Timeout: 5 * time.Second}, // Таймаут для межсервисных запросов
+		peers:  peer.NewClient(5 * time.Second),
+		hints:  hints,
+		clock:  kv.NewClock(string(self)),
+		txns:   txns,
+		raft:   consensus,
+		gossip: members,
+
+		closing: make(chan struct{}),
 	}
 }
 
-// proxyRequest выполняет запрос к другой ноде
-func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, targetID hashring.NodeID) {
+// proxyRequest выполняет запрос к другой ноде.
+// Если нода недоступна, в w ничего не пишется и возвращается ошибка,
+// чтобы вызывающий мог попробовать следующую реплику.
+func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, targetID hashring.NodeID) error {
 	targetAddr, ok := h.ring.GetNodeAddr(targetID)
 	if !ok {
-		http.Error(w, "node address not found", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("node address not found: %s", targetID)
 	}
 
 	url := fmt.Sprintf("http://%s%s", targetAddr, r.URL.RequestURI())
@@ -41,8 +73,7 @@ func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, targetID
 	// Создаем новый запрос (копируем тело, если есть)
 	proxyReq, err := http.NewRequest(r.Method, url, r.Body)
 	if err != nil {
-		http.Error(w, "proxy error", http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	// Копируем заголовки (Content-Type и т.д.)
@@ -51,17 +82,21 @@ func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, targetID
 			proxyReq.Header.Add(name, value)
 		}
 	}
+	h.tagEpoch(proxyReq.Header)
 
 	// Выполняем запрос
 	resp, err := h.client.Do(proxyReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("proxy failed: %v", err), http.StatusBadGateway)
-		return
+		return err
 	}
 	defer resp.Body.Close()
+	h.observeEpoch(resp.Header)
 
-	// Копируем заголовки ответа
+	// Копируем заголовки ответа; эпоху в ответе сообщает эта нода
 	for name, values := range resp.Header {
+		if name == http.CanonicalHeaderKey(epochHeader) {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(name, value)
 		}
@@ -69,6 +104,7 @@ func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, targetID
 
 	w.WriteHeader(resp.StatusCode)
 	io.Copy(w, resp.Body)
+	return nil
 }
 
 func (h *Handler) Put(w http.ResponseWriter, r *http.Request) {
@@ -78,14 +114,37 @@ func (h *Handler) Put(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	node, err := h.ring.PrimaryNode(key)
+	replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
 	if err != nil {
 		http.Error(w, "no nodes", http.StatusServiceUnavailable)
 		return
 	}
 
-	if node != h.self {
-		h.proxyRequest(w, r, node)
+	need, err := h.quorumParam(r, "w", h.cfg.WriteQuorum, len(replicas))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cond, err := parseCondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.raft != nil {
+		h.consensusWrite(w, r, kv.Command{Op: kv.OpPut, Key: key, Cond: cond, ExpiresAt: expiresAt(ttl)})
+		return
+	}
+
+	// Условную запись проверяет и применяет только владелец ключа
+	if !cond.IsZero() && h.forwardToPrimary(w, r, replicas) {
 		return
 	}
 
@@ -94,7 +153,23 @@ func (h *Handler) Put(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad body", http.StatusBadRequest)
 		return
 	}
-	h.store.Put(key, body)
+
+	if !cond.IsZero() {
+		h.writeConditional(w, key, replicas, need, cond, kv.Entry{Value: body, ExpiresAt: expiresAt(ttl)})
+		return
+	}
+	if h.forwardWrite(w, r, key, replicas, body) {
+		return
+	}
+
+	// Версию и срок жизни назначает координатор, чтобы у всех реплик они совпадали
+	entry := kv.Entry{Value: body, Version: h.clock.Now(), ExpiresAt: expiresAt(ttl)}
+
+	if err := h.writeUnlocked(key, replicas, need, entry); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set(peer.VersionHeader, entry.Version.String())
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -105,27 +180,33 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	node, err := h.ring.PrimaryNode(key)
+	replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
 	if err != nil {
 		http.Error(w, "no nodes", http.StatusServiceUnavailable)
 		return
 	}
 
-	if node != h.self {
-		h.proxyRequest(w, r, node)
+	need, err := h.quorumParam(r, "r", h.cfg.ReadQuorum, len(replicas))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	val, err := h.store.Get(key)
-	if errors.Is(err, kv.ErrNotFound) {
-		http.Error(w, "not found", http.StatusNotFound)
+	if h.raft != nil && h.forwardToLeader(w, r, key) {
 		return
 	}
+
+	latest, found, err := h.readKey(key, replicas, need)
 	if err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	_, _ = w.Write(val)
+	if !found {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	peer.SetEntryHeaders(w.Header(), latest)
+	_, _ = w.Write(latest.Value)
 }
 
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
@@ -135,26 +216,122 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	node, err := h.ring.PrimaryNode(key)
+	replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
 	if err != nil {
 		http.Error(w, "no nodes", http.StatusServiceUnavailable)
 		return
 	}
 
-	if node != h.self {
-		h.proxyRequest(w, r, node)
+	need, err := h.quorumParam(r, "w", h.cfg.WriteQuorum, len(replicas))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cond, err := parseCondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cond.NotExists {
+		http.Error(w, "If-None-Match is not supported for DELETE", http.StatusBadRequest)
+		return
+	}
+
+	if h.raft != nil {
+		h.consensusWrite(w, r, kv.Command{Op: kv.OpDelete, Key: key, Cond: cond})
 		return
 	}
 
-	h.store.Delete(key)
+	if !cond.IsZero() {
+		if !h.forwardToPrimary(w, r, replicas) {
+			h.writeConditional(w, key, replicas, need, cond, kv.Entry{Deleted: true})
+		}
+		return
+	}
+
+	if h.forwardWrite(w, r, key, replicas, nil) {
+		return
+	}
+
+	tombstone := kv.Entry{Version: h.clock.Now(), Deleted: true}
+
+	if err := h.writeUnlocked(key, replicas, need, tombstone); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set(peer.VersionHeader, tombstone.Version.String())
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// readKey читает ключ с кворумом need и в фоне запускает read repair.
+// found = false, если ключа нет, он удален или истек.
+// В режиме raft ключ читается на лидере группы, реплики и кворум не используются.
+func (h *Handler) readKey(key string, replicas []hashring.NodeID, need int) (kv.Entry, bool, error) {
+	if h.raft != nil {
+		return h.consensusRead(key)
+	}
+	call, ok := h.quorum(replicas, need,
+		func() (kv.Entry, error) { return h.store.GetEntry(key) },
+		func(ctx context.Context, _ hashring.NodeID, addr string) (kv.Entry, error) {
+			return h.peers.Get(ctx, addr, key)
+		},
+	)
+	if !ok {
+		return kv.Entry{}, false, fmt.Errorf("read quorum not reached: %d/%d replicas responded", len(call.acked), need)
+	}
+
+	latest, found := newest(call.acked)
+	go h.readRepair(key, call)
+
+	if !found || latest.Deleted || latest.Expired(time.Now()) {
+		return kv.Entry{}, false, nil
+	}
+	return latest, true, nil
+}
+
+// writeKey рассылает запись всем репликам и ждет подтверждения need из них.
+// В режиме raft запись проходит через лог группы ключа.
+func (h *Handler) writeKey(key string, replicas []hashring.NodeID, need int, entry kv.Entry) error {
+	if h.raft != nil {
+		return h.consensusWriteEntry(key, entry)
+	}
+	call, ok := h.replicate(key, replicas, need, entry)
+	if !ok {
+		return fmt.Errorf("write quorum not reached: %d/%d replicas acknowledged", len(call.acked), need)
+	}
+	return nil
+}
+
+// writeUnlocked применяет запись клиента без условий. Владелец ключа сначала
+// записывает ее у себя, проверяя блокировки транзакций, и только затем
+// рассылает остальным репликам. Если ключ заблокирован, возвращается kv.ErrLocked.
+func (h *Handler) writeUnlocked(key string, replicas []hashring.NodeID, need int, entry kv.Entry) error {
+	if h.raft == nil && replicas[0] == h.self {
+		if _, err := h.store.PutUnlocked(key, entry); err != nil {
+			return err
+		}
+	}
+	return h.writeKey(key, replicas, need, entry)
+}
+
+// writeError отвечает на неудавшуюся запись: 409, если ключ заблокирован
+// транзакцией, иначе 503
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, kv.ErrLocked) {
+		http.Error(w, "key is locked by a transaction", http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
 }
 
+// InternalPut принимает ключ при миграции. Конфликт разрешается в пользу
+// более новой версии: локальное значение остается, только если оно новее.
 func (h *Handler) InternalPut(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
@@ -162,16 +339,107 @@ func (h *Handler) InternalPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	entry, err := h.readEntry(r)
 	if err != nil {
-		http.Error(w, "bad body", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	wasWritten := h.store.PutIfNotExists(key, body)
+	wasWritten, err := h.store.PutEntry(key, entry)
+	if err != nil {
+		log.Printf("ERR: Migration write of %s failed: %v", key, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
 	if !wasWritten {
-		log.Printf("Migration conflict resolved: kept local value for key %s", key)
+		log.Printf("Migration conflict resolved: kept newer local version for key %s", key)
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// InternalReplicaGet отдает локальную реплику ключа (в том числе tombstone) без проксирования
+func (h *Handler) InternalReplicaGet(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.store.GetEntry(key)
+	if errors.Is(err, kv.ErrNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	peer.SetEntryHeaders(w.Header(), entry)
+	_, _ = w.Write(entry.Value)
+}
+
+// InternalReplicaPut записывает реплику ключа, присланную координатором записи
+func (h *Handler) InternalReplicaPut(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.readEntry(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.store.PutEntry(key, entry); err != nil {
+		log.Printf("ERR: Replica write of %s failed: %v", key, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InternalReplicaDelete записывает tombstone ключа по запросу координатора
+func (h *Handler) InternalReplicaDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.readEntry(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry.Value = nil
+	entry.Deleted = true
+
+	if _, err := h.store.PutEntry(key, entry); err != nil {
+		log.Printf("ERR: Replica delete of %s failed: %v", key, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readEntry собирает запись из тела внутреннего запроса и заголовков с версией.
+// Если версия не передана, запись получает новую локальную версию.
+func (h *Handler) readEntry(r *http.Request) (kv.Entry, error) {
+	entry, err := peer.ParseEntryHeaders(r.Header)
+	if err != nil {
+		return kv.Entry{}, err
+	}
+	if entry.Version.IsZero() {
+		entry.Version = h.clock.Now()
+	} else {
+		h.clock.Observe(entry.Version)
+	}
+
+	if entry.Value, err = io.ReadAll(r.Body); err != nil {
+		return kv.Entry{}, errors.New("bad body")
+	}
+	return entry, nil
+}
@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"kv-store/internal/handoff"
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+)
+
+// replicaResult - ответ одной реплики на запрос координатора
+type replicaResult struct {
+	node  hashring.NodeID
+	entry kv.Entry
+	err   error
+}
+
+// responded - реплика ответила по существу (в том числе "ключа нет")
+func (r replicaResult) responded() bool {
+	return r.err == nil || errors.Is(r.err, kv.ErrNotFound)
+}
+
+// quorumCall - запрос, разосланный всем репликам ключа
+type quorumCall struct {
+	acked   []replicaResult
+	results <-chan replicaResult
+	pending int
+}
+
+// rest дожидается ответов реплик, не вошедших в кворум,
+// и возвращает те из них, что ответили по существу
+func (c *quorumCall) rest() []replicaResult {
+	late := make([]replicaResult, 0, c.pending)
+	for ; c.pending > 0; c.pending-- {
+		if res := <-c.results; res.responded() {
+			late = append(late, res)
+		}
+	}
+	return late
+}
+
+// quorum параллельно выполняет операцию на всех репликах и возвращает,
+// как только need из них ответили, либо когда ответили все.
+// Запросы к оставшимся репликам дорабатывают в фоне.
+func (h *Handler) quorum(
+	replicas []hashring.NodeID,
+	need int,
+	local func() (kv.Entry, error),
+	remote func(ctx context.Context, node hashring.NodeID, addr string) (kv.Entry, error),
+) (*quorumCall, bool) {
+	results := make(chan replicaResult, len(replicas))
+
+	for _, id := range replicas {
+		go func(id hashring.NodeID) {
+			res := replicaResult{node: id}
+			if id == h.self {
+				res.entry, res.err = local()
+			} else if addr, ok := h.ring.GetNodeAddr(id); !ok {
+				res.err = fmt.Errorf("no addr for node %s", id)
+			} else {
+				res.entry, res.err = remote(context.Background(), id, addr)
+			}
+			results <- res
+		}(id)
+	}
+
+	call := &quorumCall{
+		acked:   make([]replicaResult, 0, need),
+		results: results,
+		pending: len(replicas),
+	}
+	for call.pending > 0 && len(call.acked) < need {
+		res := <-results
+		call.pending--
+		if !res.responded() {
+			log.Printf("ERR: Replica %s failed: %v", res.node, res.err)
+			continue
+		}
+		call.acked = append(call.acked, res)
+	}
+	return call, len(call.acked) >= need
+}
+
+// replicate рассылает запись (значение или tombstone) всем репликам ключа.
+// Для недоступной реплики координатор сохраняет подсказку, чтобы доставить
+// запись позже, но в кворум она не засчитывается: подсказка лежит на одной
+// ноде-координаторе, а не на реплике, и может быть отброшена при переполнении.
+func (h *Handler) replicate(key string, replicas []hashring.NodeID, need int, e kv.Entry) (*quorumCall, bool) {
+	return h.quorum(replicas, need,
+		func() (kv.Entry, error) {
+			_, err := h.store.PutEntry(key, e)
+			return kv.Entry{}, err
+		},
+		func(ctx context.Context, node hashring.NodeID, addr string) (kv.Entry, error) {
+			err := h.peers.Put(ctx, addr, key, e)
+			var rejected *peer.StatusError
+			if err != nil && !errors.As(err, &rejected) {
+				log.Printf("Replica %s unreachable (%v), storing hint for key %s", node, err, key)
+				if herr := h.hints.Add(node, handoff.Hint{Key: key, Entry: e}); herr != nil && !errors.Is(herr, handoff.ErrTooManyHints) {
+					log.Printf("ERR: Failed to store hint for key %s: %v", key, herr)
+				}
+			}
+			return kv.Entry{}, err
+		},
+	)
+}
+
+// quorumParam читает размер кворума из query-параметра name.
+// Если параметр не задан, используется def; он не может превышать число
+// реплик ключа, доступных в кольце (кластер меньше replication_factor).
+// Явно заданный кворум больше replication_factor - ошибка запроса, а больше
+// числа доступных реплик не уменьшается: такой запрос завершится 503.
+func (h *Handler) quorumParam(r *http.Request, name string, def, replicas int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return min(def, replicas), nil
+	}
+	q, err := strconv.Atoi(raw)
+	if err != nil || q <= 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	if q > h.cfg.ReplicationFactor {
+		return 0, fmt.Errorf("%s=%d exceeds replication factor %d", name, q, h.cfg.ReplicationFactor)
+	}
+	return q, nil
+}
@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+	"kv-store/internal/raft"
+)
+
+// raftTimeout - сколько ждать лидера группы и фиксации записи
+const raftTimeout = 5 * time.Second
+
+// В режиме consistency: raft каждым шардом ключей владеет группа Raft.
+// Записи и чтения выполняет лидер группы: запись фиксируется в логе
+// большинства группы, а чтение подтверждается через ReadIndex.
+
+func (h *Handler) shardOf(key string) int {
+	return hashring.Shard(key, h.raft.Groups())
+}
+
+// owner - нода, которая обрабатывает ключ: лидер группы в режиме raft,
+// иначе владелец ключа на кольце
+func (h *Handler) owner(ctx context.Context, key string) (hashring.NodeID, error) {
+	if h.raft == nil {
+		return h.ring.PrimaryNode(key)
+	}
+	ctx, cancel := context.WithTimeout(ctx, raftTimeout)
+	defer cancel()
+	leader, err := h.raft.Leader(ctx, h.shardOf(key))
+	return hashring.NodeID(leader), err
+}
+
+// forwardToLeader отправляет запрос лидеру группы ключа, если это не текущая нода.
+// Возвращает true, если запрос обработан (проксирован или завершился ошибкой).
+func (h *Handler) forwardToLeader(w http.ResponseWriter, r *http.Request, key string) bool {
+	if r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	leader, err := h.owner(r.Context(), key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no leader for shard %d: %v", h.shardOf(key), err), http.StatusServiceUnavailable)
+		return true
+	}
+	if leader == h.self {
+		return false
+	}
+
+	r.Header.Set(forwardedHeader, string(h.self))
+	if err := h.proxyRequest(w, r, leader); err != nil {
+		http.Error(w, fmt.Sprintf("leader %s unavailable: %v", leader, err), http.StatusBadGateway)
+	}
+	return true
+}
+
+// consensusWrite выполняет PUT, DELETE или инкремент через лог группы ключа
+func (h *Handler) consensusWrite(w http.ResponseWriter, r *http.Request, cmd kv.Command) {
+	if h.forwardToLeader(w, r, cmd.Key) {
+		return
+	}
+	if cmd.Op == kv.OpPut {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		cmd.Value = body
+	}
+
+	entry, err := h.propose(r.Context(), cmd)
+	switch {
+	case errors.Is(err, kv.ErrPreconditionFailed):
+		if !entry.Version.IsZero() && !entry.Deleted && !entry.Expired(time.Unix(0, cmd.Now)) {
+			w.Header().Set(peer.VersionHeader, entry.Version.String())
+		}
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, kv.ErrNotInteger), errors.Is(err, kv.ErrOverflow):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set(peer.VersionHeader, entry.Version.String())
+	if cmd.Op == kv.OpIncr {
+		_, _ = w.Write(entry.Value)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// propose предлагает команду группе ключа и ждет ее применения.
+// Версию (если не задана) и время для проверки TTL назначает лидер.
+func (h *Handler) propose(ctx context.Context, cmd kv.Command) (kv.Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, raftTimeout)
+	defer cancel()
+
+	if cmd.Version.IsZero() {
+		cmd.Version = h.clock.Now()
+	}
+	if cmd.Now == 0 {
+		cmd.Now = time.Now().UnixNano()
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return kv.Entry{}, err
+	}
+	res, err := h.raft.Propose(ctx, h.shardOf(cmd.Key), data)
+	if err != nil {
+		return kv.Entry{}, err
+	}
+	out := res.(kv.CommandResult)
+	if !out.Entry.Version.IsZero() {
+		h.clock.Observe(out.Entry.Version)
+	}
+	if out.Err != nil && !errors.Is(out.Err, kv.ErrPreconditionFailed) &&
+		!errors.Is(out.Err, kv.ErrNotInteger) && !errors.Is(out.Err, kv.ErrOverflow) {
+		log.Printf("ERR: Applying %s of %s failed: %v", cmd.Op, cmd.Key, out.Err)
+	}
+	return out.Entry, out.Err
+}
+
+// consensusRead линеаризуемо читает ключ на лидере группы
+func (h *Handler) consensusRead(key string) (kv.Entry, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), raftTimeout)
+	defer cancel()
+	if err := h.raft.ReadIndex(ctx, h.shardOf(key)); err != nil {
+		return kv.Entry{}, false, err
+	}
+
+	e, err := h.store.GetEntry(key)
+	if errors.Is(err, kv.ErrNotFound) || err == nil && (e.Deleted || e.Expired(time.Now())) {
+		return kv.Entry{}, false, nil
+	}
+	if err != nil {
+		return kv.Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+// consensusWriteEntry записывает готовую запись (значение или tombstone) через лог группы
+func (h *Handler) consensusWriteEntry(key string, entry kv.Entry) error {
+	cmd := kv.Command{Op: kv.OpPut, Key: key, Value: entry.Value, ExpiresAt: entry.ExpiresAt, Version: entry.Version}
+	if entry.Deleted {
+		cmd = kv.Command{Op: kv.OpDelete, Key: key, Version: entry.Version}
+	}
+	_, err := h.propose(context.Background(), cmd)
+	return err
+}
+
+func (h *Handler) InternalRaftVote(w http.ResponseWriter, r *http.Request) {
+	var req raft.VoteRequest
+	if !h.decodeRaft(w, r, &req) {
+		return
+	}
+	resp, err := h.raft.RequestVote(req)
+	writeRaft(w, resp, err)
+}
+
+func (h *Handler) InternalRaftAppend(w http.ResponseWriter, r *http.Request) {
+	var req raft.AppendRequest
+	if !h.decodeRaft(w, r, &req) {
+		return
+	}
+	resp, err := h.raft.AppendEntries(req)
+	writeRaft(w, resp, err)
+}
+
+func (h *Handler) InternalRaftSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req raft.SnapshotRequest
+	if !h.decodeRaft(w, r, &req) {
+		return
+	}
+	resp, err := h.raft.InstallSnapshot(req)
+	writeRaft(w, resp, err)
+}
+
+func (h *Handler) InternalRaftLeader(w http.ResponseWriter, r *http.Request) {
+	var req raft.LeaderRequest
+	if !h.decodeRaft(w, r, &req) {
+		return
+	}
+	resp, err := h.raft.FindLeader(req)
+	writeRaft(w, resp, err)
+}
+
+func (h *Handler) decodeRaft(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if h.raft == nil {
+		http.Error(w, "raft consistency mode is disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeRaft(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"kv-store/internal/kv"
+)
+
+// newest выбирает самую новую версию (значение или tombstone) среди ответов реплик.
+// found = false, если ни у одной реплики ключа нет.
+func newest(results []replicaResult) (kv.Entry, bool) {
+	var (
+		best  kv.Entry
+		found bool
+	)
+	for _, res := range results {
+		if res.err != nil {
+			continue
+		}
+		if !found || res.entry.Version.Compare(best.Version) > 0 {
+			best = res.entry
+			found = true
+		}
+	}
+	return best, found
+}
+
+// readRepair дожидается ответов всех реплик и дописывает самую новую
+// версию на те, у которых ее нет или она устарела
+func (h *Handler) readRepair(key string, call *quorumCall) {
+	results := append(call.acked, call.rest()...)
+
+	latest, found := newest(results)
+	if !found {
+		return
+	}
+	h.clock.Observe(latest.Version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, res := range results {
+		if res.err == nil && res.entry.Version.Compare(latest.Version) >= 0 {
+			continue
+		}
+		// Отсутствующий ключ и так эквивалентен удаленному
+		if res.err != nil && latest.Deleted {
+			continue
+		}
+
+		if res.node == h.self {
+			if _, err := h.store.PutEntry(key, latest); err != nil {
+				log.Printf("ERR: Read repair of %s on %s failed: %v", key, res.node, err)
+				continue
+			}
+		} else if addr, ok := h.ring.GetNodeAddr(res.node); ok {
+			if err := h.peers.Put(ctx, addr, key, latest); err != nil {
+				log.Printf("ERR: Read repair of %s on %s failed: %v", key, res.node, err)
+				continue
+			}
+		} else {
+			continue
+		}
+		log.Printf("Read repair: updated key %s on replica %s", key, res.node)
+	}
+}
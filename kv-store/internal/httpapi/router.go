@@ -7,7 +7,30 @@ func NewRouter(h *Handler) http.Handler {
 	mux.HandleFunc("/put", h.Put)
 	mux.HandleFunc("/get", h.Get)
 	mux.HandleFunc("/delete", h.Delete)
+	mux.HandleFunc("/scan", h.Scan)
+	mux.HandleFunc("/mget", h.MGet)
+	mux.HandleFunc("/mput", h.MPut)
+	mux.HandleFunc("/txn", h.Txn)
+	mux.HandleFunc("/incr", h.Incr)
+	mux.HandleFunc("/decr", h.Decr)
+	mux.HandleFunc("/watch", h.Watch)
 	mux.HandleFunc("/health", h.Health)
+	mux.HandleFunc("/admin/decommission", h.Decommission)
 	mux.HandleFunc("/internal/put", h.InternalPut)
+	mux.HandleFunc("/internal/replica/get", h.InternalReplicaGet)
+	mux.HandleFunc("/internal/replica/put", h.InternalReplicaPut)
+	mux.HandleFunc("/internal/replica/delete", h.InternalReplicaDelete)
+	mux.HandleFunc("/internal/scan", h.InternalScan)
+	mux.HandleFunc("/internal/watch", h.InternalWatch)
+	mux.HandleFunc("/internal/raft/vote", h.InternalRaftVote)
+	mux.HandleFunc("/internal/raft/append", h.InternalRaftAppend)
+	mux.HandleFunc("/internal/raft/snapshot", h.InternalRaftSnapshot)
+	mux.HandleFunc("/internal/raft/leader", h.InternalRaftLeader)
+	mux.HandleFunc("/internal/gossip/ping", h.InternalGossipPing)
+	mux.HandleFunc("/internal/gossip/indirect-ping", h.InternalGossipIndirectPing)
+	mux.HandleFunc("/internal/gossip/sync", h.InternalGossipSync)
+	mux.HandleFunc("/internal/txn/prepare", h.InternalTxnPrepare)
+	mux.HandleFunc("/internal/txn/commit", h.InternalTxnCommit)
+	mux.HandleFunc("/internal/txn/abort", h.InternalTxnAbort)
 	return mux
 }
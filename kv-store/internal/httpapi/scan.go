@@ -0,0 +1,231 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+)
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+
+	// maxScanRounds ограничивает число дозапросов к нодам за один /scan,
+	// если страницы нод состоят в основном из tombstone
+	maxScanRounds = 8
+)
+
+type scanResponse struct {
+	Items  []scanResponseItem `json:"items"`
+	Cursor string             `json:"cursor,omitempty"`
+}
+
+type scanResponseItem struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Version string `json:"version"`
+}
+
+// nodePage - страница записей одной ноды
+type nodePage struct {
+	node  hashring.NodeID
+	items []kv.Item
+	err   error
+}
+
+// Scan возвращает ключи из диапазона в порядке возрастания.
+// Каждая нода отдает свою часть, координатор сливает страницы и для ключа,
+// лежащего на нескольких репликах, берет самую новую версию.
+func (h *Handler) Scan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+
+	limit := defaultScanLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(v, maxScanLimit)
+	}
+
+	// Диапазон [from, end): пересечение start/end и префикса
+	from := max(q.Get("start"), prefix)
+	end := q.Get("end")
+	if pe := prefixEnd(prefix); pe != "" && (end == "" || pe < end) {
+		end = pe
+	}
+	if raw := q.Get("cursor"); raw != "" {
+		next, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		from = max(from, string(next))
+	}
+	keysOnly := q.Get("keys_only") == "true"
+
+	nodes := h.ring.Nodes()
+	if len(nodes) == 0 {
+		http.Error(w, "no nodes", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := scanResponse{Items: []scanResponseItem{}}
+	now := time.Now()
+	for round := 0; ; round++ {
+		if end != "" && from >= end {
+			break
+		}
+
+		pages, failed := h.scanNodes(nodes, from, end, limit)
+		// Пока отвечает хотя бы одна реплика каждого ключа, результат полный
+		if failed >= h.cfg.ReplicationFactor {
+			http.Error(w, fmt.Sprintf("scan failed: %d/%d nodes unavailable", failed, len(nodes)), http.StatusServiceUnavailable)
+			return
+		}
+
+		items, boundary, more := mergePages(pages, limit)
+		for _, it := range items {
+			if !it.Entry.Deleted && !it.Entry.Expired(now) {
+				item := scanResponseItem{Key: it.Key, Version: it.Entry.Version.String()}
+				if !keysOnly {
+					item.Value = it.Entry.Value
+				}
+				resp.Items = append(resp.Items, item)
+			}
+			if len(resp.Items) == limit {
+				boundary, more = it.Key, true
+				break
+			}
+		}
+
+		if !more {
+			break
+		}
+		// Следующий ключ после boundary
+		from = boundary + "\x00"
+		if len(resp.Items) == limit || round+1 == maxScanRounds {
+			resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(from))
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// scanNodes параллельно запрашивает страницу у каждой ноды.
+// Возвращает ответившие страницы и число недоступных нод.
+func (h *Handler) scanNodes(nodes []hashring.NodeID, from, end string, limit int) ([]nodePage, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pages := make([]nodePage, len(nodes))
+	var wg sync.WaitGroup
+	for i, id := range nodes {
+		wg.Add(1)
+		go func(i int, id hashring.NodeID) {
+			defer wg.Done()
+			page := nodePage{node: id}
+			if id == h.self {
+				page.items, page.err = h.store.Scan(from, end, limit)
+			} else if addr, ok := h.ring.GetNodeAddr(id); !ok {
+				page.err = fmt.Errorf("no addr for node %s", id)
+			} else {
+				page.items, page.err = h.peers.Scan(ctx, addr, from, end, limit)
+			}
+			pages[i] = page
+		}(i, id)
+	}
+	wg.Wait()
+
+	ok := pages[:0]
+	failed := 0
+	for _, page := range pages {
+		if page.err != nil {
+			log.Printf("ERR: Scan on node %s failed: %v", page.node, page.err)
+			failed++
+			continue
+		}
+		ok = append(ok, page)
+	}
+	return ok, failed
+}
+
+// mergePages сливает страницы нод в один отсортированный список, оставляя
+// для каждого ключа самую новую версию. Если какая-то нода вернула полную
+// страницу, у нее могут быть еще ключи, поэтому результат обрезается по
+// наименьшему последнему ключу среди полных страниц (boundary, more = true).
+func mergePages(pages []nodePage, limit int) (items []kv.Item, boundary string, more bool) {
+	for _, page := range pages {
+		if len(page.items) < limit {
+			continue
+		}
+		last := page.items[len(page.items)-1].Key
+		if !more || last < boundary {
+			boundary, more = last, true
+		}
+	}
+
+	latest := make(map[string]kv.Entry)
+	for _, page := range pages {
+		for _, it := range page.items {
+			if more && it.Key > boundary {
+				break
+			}
+			if cur, ok := latest[it.Key]; !ok || it.Entry.Version.Compare(cur.Version) > 0 {
+				latest[it.Key] = it.Entry
+			}
+		}
+	}
+
+	items = make([]kv.Item, 0, len(latest))
+	for key, e := range latest {
+		items = append(items, kv.Item{Key: key, Entry: e})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, boundary, more
+}
+
+// prefixEnd возвращает наименьший ключ, больший всех ключей с префиксом prefix.
+// Пустая строка - верхней границы нет.
+func prefixEnd(prefix string) string {
+	p := strings.TrimRight(prefix, "\xff")
+	if p == "" {
+		return ""
+	}
+	return p[:len(p)-1] + string([]byte{p[len(p)-1] + 1})
+}
+
+// InternalScan отдает локальные записи ноды (включая tombstone) для /scan
+func (h *Handler) InternalScan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	items, err := h.store.Scan(q.Get("from"), q.Get("end"), limit)
+	if err != nil {
+		log.Printf("ERR: Local scan failed: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = peer.WriteItems(w, items)
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// epochHeader - эпоха состава, по которому нода выбрала получателя запроса.
+// Ее передают пересылаемые запросы и ответы на них: нода, которая видит
+// эпоху новее своей, запрашивает состав у seed, не дожидаясь heartbeat.
+const epochHeader = "X-KV-Topology-Epoch"
+
+// OnStaleTopology задает, как запросить свежий состав кластера
+func (h *Handler) OnStaleTopology(fn func()) {
+	h.refreshTopology = fn
+}
+
+// tagEpoch помечает пересылаемый запрос (или ответ на него) эпохой кольца этой ноды
+func (h *Handler) tagEpoch(header http.Header) {
+	if epoch := h.ring.Epoch(); epoch != 0 {
+		header.Set(epochHeader, strconv.FormatUint(epoch, 10))
+	} else {
+		header.Del(epochHeader)
+	}
+}
+
+// observeEpoch сравнивает эпоху другой ноды со своей и, если та новее,
+// запрашивает свежий состав. Возвращает -1, 0 или 1, если эпоха другой
+// ноды меньше, равна или больше; 0 - и если одна из эпох неизвестна.
+func (h *Handler) observeEpoch(header http.Header) int {
+	theirs, _ := strconv.ParseUint(header.Get(epochHeader), 10, 64)
+	ours := h.ring.Epoch()
+	switch {
+	case theirs == 0 || ours == 0 || theirs == ours:
+		return 0
+	case theirs < ours:
+		return -1
+	}
+	if h.refreshTopology != nil {
+		h.refreshTopology()
+	}
+	return 1
+}
+
+// staleForward проверяет запрос, который другая нода уже переслала сюда.
+// true - отправитель выбрал получателя по составу старше, чем у этой ноды:
+// тогда запрос маршрутизируется заново по своему кольцу, а не обрабатывается
+// как есть. Следующая нода получит эпоху этой ноды, поэтому запрос
+// не будет пересылаться по кругу: эпоха на каждом шаге только растет.
+func (h *Handler) staleForward(w http.ResponseWriter, r *http.Request) bool {
+	stale := h.observeEpoch(r.Header) < 0
+	// Ответ сообщает отправителю эпоху этой ноды, чтобы он обновил состав
+	h.tagEpoch(w.Header())
+	return stale
+}
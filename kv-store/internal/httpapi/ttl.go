@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ttlHeader - альтернатива query-параметру ttl
+const ttlHeader = "X-KV-TTL"
+
+// parseTTL читает срок жизни ключа из ?ttl= или заголовка X-KV-TTL.
+// Принимается длительность Go ("30s", "1h") или целое число секунд.
+// 0 - ключ без TTL.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("ttl")
+	if raw == "" {
+		raw = r.Header.Get(ttlHeader)
+	}
+	return parseTTLValue(raw)
+}
+
+// parseTTLValue разбирает значение TTL; пустая строка - без TTL
+func parseTTLValue(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		secs, convErr := strconv.ParseInt(raw, 10, 64)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid ttl %q", raw)
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// expiresAt переводит TTL в абсолютный срок, одинаковый на всех репликах
+func expiresAt(ttl time.Duration) int64 {
+	if ttl == 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
@@ -0,0 +1,512 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/txn"
+)
+
+const (
+	// maxTxnKeys ограничивает число ключей в одной транзакции
+	maxTxnKeys = 100
+
+	// txnLockTimeout - сколько участник держит ключи после prepare,
+	// если решение координатора так и не пришло. Решение commit, доставленное
+	// позже (координатор долго был недоступен), все равно применяется с версией,
+	// назначенной при фиксации: запись, прошедшая после снятия блокировок
+	// с более новой версией, перекроет транзакцию на своем ключе.
+	txnLockTimeout = 30 * time.Second
+)
+
+// txnLog - журнал решений координатора (txn.Log)
+type txnLog interface {
+	Begin(rec txn.Record) error
+	Update(rec txn.Record) error
+	Release(id string)
+}
+
+type txnRequest struct {
+	Compare []txnCompare `json:"compare"`
+	Ops     []txnOp      `json:"ops"`
+}
+
+// txnCompare - условие на ключ, аналог If-Match / If-None-Match
+type txnCompare struct {
+	Key       string `json:"key"`
+	Version   string `json:"version,omitempty"`
+	Exists    bool   `json:"exists,omitempty"`
+	NotExists bool   `json:"not_exists,omitempty"`
+}
+
+type txnOp struct {
+	Op    string `json:"op"` // put | delete
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+type txnResponse struct {
+	Committed bool     `json:"committed"`
+	Version   string   `json:"version,omitempty"`
+	Failed    []string `json:"failed,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// txnCondition - условие на ключ во внутреннем prepare
+type txnCondition struct {
+	Key  string       `json:"key"`
+	Cond kv.Condition `json:"cond"`
+}
+
+type prepareRequest struct {
+	ID      string         `json:"id"`
+	Keys    []string       `json:"keys"`
+	Compare []txnCondition `json:"compare,omitempty"`
+}
+
+type prepareResponse struct {
+	OK     bool     `json:"ok"`
+	Locked bool     `json:"locked,omitempty"`
+	Failed []string `json:"failed,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	// MaxVersion - самая новая версия среди ключей участника; версия
+	// транзакции назначается новее нее, чтобы запись не проиграла локальной
+	MaxVersion kv.Version `json:"max_version"`
+}
+
+type decisionRequest struct {
+	ID      string     `json:"id"`
+	Version kv.Version `json:"version"`
+	Ops     []txn.Op   `json:"ops,omitempty"`
+}
+
+// txnPart - ключи транзакции, принадлежащие одному владельцу
+type txnPart struct {
+	prepare prepareRequest
+	ops     []txn.Op
+}
+
+// Txn атомарно применяет набор записей и удалений на нескольких шардах.
+// Нода, принявшая запрос, координирует двухфазный коммит: владельцы ключей
+// блокируют их и проверяют условия (prepare), после чего координатор
+// записывает решение в свой журнал и рассылает его участникам.
+func (h *Handler) Txn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.raft != nil {
+		// Ключи транзакции лежат в разных группах Raft, а 2PC поверх групп не реализован
+		http.Error(w, "transactions are not supported in raft consistency mode", http.StatusNotImplemented)
+		return
+	}
+
+	var req txnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	parts, err := h.planTxn(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rec := txn.Record{
+		ID:           newTxnID(),
+		State:        txn.StatePrepare,
+		Participants: make(map[hashring.NodeID][]txn.Op, len(parts)),
+	}
+	for node, part := range parts {
+		part.prepare.ID = rec.ID
+		rec.Participants[node] = part.ops
+	}
+	if err := h.txns.Begin(rec); err != nil {
+		log.Printf("ERR: Failed to log transaction %s: %v", rec.ID, err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	defer h.txns.Release(rec.ID)
+
+	status, resp := h.prepareAll(r.Context(), parts)
+	if status == http.StatusOK {
+		version := h.clock.Now()
+		// Решение в журнале координатора - момент фиксации транзакции.
+		// Пока оно не записано, транзакция остается в prepare и отменяется.
+		if err := h.txns.Update(txn.Record{ID: rec.ID, State: txn.StateCommit, Version: version}); err != nil {
+			log.Printf("ERR: Failed to log commit of transaction %s: %v", rec.ID, err)
+			status, resp = http.StatusInternalServerError, txnResponse{Error: "failed to log commit decision"}
+		} else {
+			rec.State, rec.Version = txn.StateCommit, version
+		}
+	}
+
+	if rec.State != txn.StateCommit {
+		rec.State = txn.StateAbort
+		if err := h.txns.Update(txn.Record{ID: rec.ID, State: rec.State}); err != nil {
+			log.Printf("ERR: Failed to log abort of transaction %s: %v", rec.ID, err)
+		}
+	}
+
+	if err := h.ResolveTxn(r.Context(), rec); err != nil {
+		// Решение уже в журнале: недоставленное довыполнит txn.Service
+		log.Printf("ERR: Transaction %s (%s) not fully delivered: %v", rec.ID, rec.State, err)
+	}
+
+	if rec.State == txn.StateCommit {
+		resp = txnResponse{Committed: true, Version: rec.Version.String()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// planTxn проверяет запрос и раскладывает ключи по владельцам
+func (h *Handler) planTxn(req txnRequest) (map[hashring.NodeID]*txnPart, error) {
+	if len(req.Ops) == 0 {
+		return nil, errors.New("no ops")
+	}
+	if len(req.Ops)+len(req.Compare) > maxTxnKeys {
+		return nil, fmt.Errorf("too many keys (max %d)", maxTxnKeys)
+	}
+
+	parts := make(map[hashring.NodeID]*txnPart)
+	seen := make(map[string]bool)
+	partFor := func(key string) (*txnPart, error) {
+		if key == "" {
+			return nil, errors.New("key required")
+		}
+		owner, err := h.ring.PrimaryNode(key)
+		if err != nil {
+			return nil, err
+		}
+		part, ok := parts[owner]
+		if !ok {
+			part = &txnPart{}
+			parts[owner] = part
+		}
+		if !seen[key] {
+			seen[key] = true
+			part.prepare.Keys = append(part.prepare.Keys, key)
+		}
+		return part, nil
+	}
+
+	for _, c := range req.Compare {
+		cond := kv.Condition{Exists: c.Exists, NotExists: c.NotExists}
+		if c.Version != "" {
+			v, err := kv.ParseVersion(c.Version)
+			if err != nil {
+				return nil, fmt.Errorf("bad version for %s: %w", c.Key, err)
+			}
+			cond.Version = v
+		}
+		if cond.IsZero() {
+			return nil, fmt.Errorf("empty compare for %s", c.Key)
+		}
+		if cond.NotExists && (cond.Exists || !cond.Version.IsZero()) {
+			return nil, fmt.Errorf("conflicting compare for %s", c.Key)
+		}
+		part, err := partFor(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		part.prepare.Compare = append(part.prepare.Compare, txnCondition{Key: c.Key, Cond: cond})
+	}
+
+	written := make(map[string]bool)
+	for _, o := range req.Ops {
+		if written[o.Key] {
+			return nil, fmt.Errorf("duplicate op for %s", o.Key)
+		}
+		written[o.Key] = true
+
+		op := txn.Op{Key: o.Key}
+		switch o.Op {
+		case "put":
+			ttl, err := parseTTLValue(o.TTL)
+			if err != nil {
+				return nil, err
+			}
+			op.Value = o.Value
+			op.ExpiresAt = expiresAt(ttl)
+		case "delete":
+			op.Delete = true
+		default:
+			return nil, fmt.Errorf("unknown op %q", o.Op)
+		}
+
+		part, err := partFor(o.Key)
+		if err != nil {
+			return nil, err
+		}
+		part.ops = append(part.ops, op)
+	}
+	return parts, nil
+}
+
+// prepareAll рассылает prepare всем участникам и сводит их голоса.
+// Возвращает http.StatusOK, если все участники готовы к коммиту.
+func (h *Handler) prepareAll(ctx context.Context, parts map[hashring.NodeID]*txnPart) (int, txnResponse) {
+	type vote struct {
+		node hashring.NodeID
+		resp prepareResponse
+		err  error
+	}
+	votes := make(chan vote, len(parts))
+	for node, part := range parts {
+		go func(node hashring.NodeID, req prepareRequest) {
+			v := vote{node: node}
+			if node == h.self {
+				v.resp = h.prepareLocal(req)
+			} else {
+				v.err = h.callTxn(ctx, node, "/internal/txn/prepare", req, &v.resp)
+			}
+			votes <- v
+		}(node, part.prepare)
+	}
+
+	status, resp := http.StatusOK, txnResponse{}
+	for range parts {
+		v := <-votes
+		switch {
+		case v.err != nil:
+			log.Printf("ERR: Transaction participant %s unavailable: %v", v.node, v.err)
+			status, resp.Error = http.StatusServiceUnavailable, fmt.Sprintf("participant %s unavailable", v.node)
+		case v.resp.Error != "":
+			status, resp.Error = http.StatusInternalServerError, v.resp.Error
+		case v.resp.Locked:
+			if status == http.StatusOK {
+				status, resp.Error = http.StatusConflict, "keys are locked by another transaction"
+			}
+		case !v.resp.OK:
+			if status == http.StatusOK || status == http.StatusPreconditionFailed {
+				status = http.StatusPreconditionFailed
+				resp.Failed = append(resp.Failed, v.resp.Failed...)
+			}
+		default:
+			h.clock.Observe(v.resp.MaxVersion)
+		}
+	}
+	return status, resp
+}
+
+// prepareLocal блокирует ключи участника, подтягивает их самые новые версии
+// с реплик и проверяет условия. Если голос отрицательный, блокировки снимаются.
+func (h *Handler) prepareLocal(req prepareRequest) prepareResponse {
+	if err := h.store.LockKeys(req.ID, req.Keys, txnLockTimeout); err != nil {
+		return prepareResponse{Locked: true}
+	}
+
+	resp := prepareResponse{OK: true}
+	for _, key := range req.Keys {
+		replicas, err := h.ring.ReplicaNodes(key, h.cfg.ReplicationFactor)
+		if err != nil {
+			resp = prepareResponse{Error: err.Error()}
+			break
+		}
+		h.syncFromReplicas(key, replicas)
+
+		if e, err := h.store.GetEntry(key); err == nil && e.Version.Compare(resp.MaxVersion) > 0 {
+			resp.MaxVersion = e.Version
+		}
+	}
+
+	for _, c := range req.Compare {
+		if resp.Error != "" {
+			break
+		}
+		_, err := h.store.Check(c.Key, c.Cond)
+		if errors.Is(err, kv.ErrPreconditionFailed) {
+			resp.OK = false
+			resp.Failed = append(resp.Failed, c.Key)
+		} else if err != nil {
+			resp = prepareResponse{Error: err.Error()}
+		}
+	}
+
+	if !resp.OK || resp.Error != "" {
+		resp.OK = false
+		h.store.UnlockKeys(req.ID)
+	}
+	return resp
+}
+
+// ResolveTxn доводит транзакцию до конца: рассылает решение участникам
+// и записывает done. Транзакция без решения (координатор упал во время
+// prepare) отменяется - коммит ни одному участнику еще не отправлялся.
+func (h *Handler) ResolveTxn(ctx context.Context, rec txn.Record) error {
+	if rec.State == txn.StatePrepare {
+		rec.State = txn.StateAbort
+		if err := h.txns.Update(txn.Record{ID: rec.ID, State: rec.State}); err != nil {
+			return err
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed error
+	)
+	for node, ops := range rec.Participants {
+		wg.Add(1)
+		go func(node hashring.NodeID, ops []txn.Op) {
+			defer wg.Done()
+			if err := h.deliverDecision(ctx, node, rec, ops); err != nil {
+				mu.Lock()
+				failed = err
+				mu.Unlock()
+			}
+		}(node, ops)
+	}
+	wg.Wait()
+	if failed != nil {
+		return failed
+	}
+	return h.txns.Update(txn.Record{ID: rec.ID, State: txn.StateDone})
+}
+
+// deliverDecision отправляет решение одному участнику. Если участник
+// недоступен, commit координатор применяет сам (как обычную запись
+// с кворумом), а abort пропускается - блокировки истекут по таймауту.
+func (h *Handler) deliverDecision(ctx context.Context, node hashring.NodeID, rec txn.Record, ops []txn.Op) error {
+	req := decisionRequest{ID: rec.ID, Version: rec.Version, Ops: ops}
+
+	if rec.State == txn.StateAbort {
+		if node == h.self {
+			h.store.UnlockKeys(rec.ID)
+		} else if err := h.callTxn(ctx, node, "/internal/txn/abort", req, nil); err != nil {
+			log.Printf("Abort of transaction %s not delivered to %s (%v), locks will expire", rec.ID, node, err)
+		}
+		return nil
+	}
+
+	if node == h.self {
+		return h.commitLocal(req)
+	}
+	err := h.callTxn(ctx, node, "/internal/txn/commit", req, nil)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Commit of transaction %s not delivered to %s (%v), applying writes directly", rec.ID, node, err)
+	return h.applyTxnOps(req)
+}
+
+// commitLocal применяет записи участника и снимает его блокировки
+func (h *Handler) commitLocal(req decisionRequest) error {
+	h.clock.Observe(req.Version)
+	if err := h.applyTxnOps(req); err != nil {
+		return err
+	}
+	h.store.UnlockKeys(req.ID)
+	return nil
+}
+
+// applyTxnOps реплицирует записи транзакции с ее общей версией.
+// Повторное применение безопасно: реплики не перезаписывают равную версию.
+func (h *Handler) applyTxnOps(req decisionRequest) error {
+	for _, op := range req.Ops {
+		entry := kv.Entry{Value: op.Value, Version: req.Version, ExpiresAt: op.ExpiresAt}
+		if op.Delete {
+			entry = kv.Entry{Version: req.Version, Deleted: true}
+		}
+
+		replicas, err := h.ring.ReplicaNodes(op.Key, h.cfg.ReplicationFactor)
+		if err != nil {
+			return err
+		}
+		if err := h.writeKey(op.Key, replicas, min(h.cfg.WriteQuorum, len(replicas)), entry); err != nil {
+			return fmt.Errorf("key %s: %w", op.Key, err)
+		}
+	}
+	return nil
+}
+
+// callTxn выполняет внутренний запрос двухфазного коммита к участнику
+func (h *Handler) callTxn(ctx context.Context, node hashring.NodeID, path string, body, out interface{}) error {
+	addr, ok := h.ring.GetNodeAddr(node)
+	if !ok {
+		return fmt.Errorf("node address not found: %s", node)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s%s", addr, path), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// InternalTxnPrepare - фаза prepare на владельце ключей
+func (h *Handler) InternalTxnPrepare(w http.ResponseWriter, r *http.Request) {
+	var req prepareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.prepareLocal(req))
+}
+
+// InternalTxnCommit применяет записи зафиксированной транзакции
+func (h *Handler) InternalTxnCommit(w http.ResponseWriter, r *http.Request) {
+	var req decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	if err := h.commitLocal(req); err != nil {
+		log.Printf("ERR: Commit of transaction %s failed: %v", req.ID, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// InternalTxnAbort снимает блокировки отмененной транзакции
+func (h *Handler) InternalTxnAbort(w http.ResponseWriter, r *http.Request) {
+	var req decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	h.store.UnlockKeys(req.ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTxnID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
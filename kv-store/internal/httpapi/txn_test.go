@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"kv-store/internal/cluster"
+	"kv-store/internal/config"
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/txn"
+)
+
+// failingCommitLog - журнал координатора, который не может записать решение commit
+type failingCommitLog struct {
+	mu     sync.Mutex
+	states []txn.State
+}
+
+func (l *failingCommitLog) Begin(rec txn.Record) error {
+	return l.Update(rec)
+}
+
+func (l *failingCommitLog) Update(rec txn.Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rec.State == txn.StateCommit {
+		return errors.New("disk full")
+	}
+	l.states = append(l.states, rec.State)
+	return nil
+}
+
+func (l *failingCommitLog) Release(string) {}
+
+// participant - владелец ключей, который голосует за коммит и запоминает решения
+type participant struct {
+	mu        sync.Mutex
+	decisions []string
+}
+
+func (p *participant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/internal/txn/prepare":
+		json.NewEncoder(w).Encode(prepareResponse{OK: true})
+	case "/internal/txn/commit", "/internal/txn/abort":
+		p.mu.Lock()
+		p.decisions = append(p.decisions, strings.TrimPrefix(r.URL.Path, "/internal/txn/"))
+		p.mu.Unlock()
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestTxnAbortsWhenCommitDecisionIsNotLogged(t *testing.T) {
+	p := &participant{}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	engine, err := kv.OpenEngine(kv.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := kv.NewStore(engine)
+	defer store.Close()
+
+	// Все ключи принадлежат другой ноде
+	ring := hashring.New(10)
+	ring.UpdateRing(cluster.Topology{Epoch: 1, Nodes: []cluster.NodeInfo{
+		{ID: "remote", Addr: strings.TrimPrefix(srv.URL, "http://")},
+	}})
+	cfg := config.HashConfig{ReplicationFactor: 1, ReadQuorum: 1, WriteQuorum: 1}
+	h := NewHandler(store, ring, "self", cfg, nil, nil, nil, nil)
+	journal := &failingCommitLog{}
+	h.txns = journal
+
+	body := `{"ops":[{"op":"put","key":"a","value":"MQ=="},{"op":"put","key":"b","value":"Mg=="}]}`
+	w := httptest.NewRecorder()
+	h.Txn(w, httptest.NewRequest(http.MethodPost, "/txn", strings.NewReader(body)))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", w.Code)
+	}
+	var resp txnResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Committed {
+		t.Fatalf("response reports a commit that is not in the log: %+v", resp)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.decisions) != 1 || p.decisions[0] != "abort" {
+		t.Fatalf("participant got %v, want [abort]", p.decisions)
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if want := []txn.State{txn.StatePrepare, txn.StateAbort, txn.StateDone}; fmt.Sprint(journal.states) != fmt.Sprint(want) {
+		t.Fatalf("logged states %v, want %v", journal.states, want)
+	}
+}
@@ -0,0 +1,290 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/peer"
+)
+
+const (
+	watchHeartbeat     = 15 * time.Second
+	watchResyncNodes   = 2 * time.Second
+	watchReconnect     = 1 * time.Second
+	watchEventBuffer   = 256
+	watchDedupCapacity = 10000
+)
+
+// watchMsg - событие от одной из нод; lagged - подписка на этой или другой ноде переполнилась
+type watchMsg struct {
+	ev     kv.Event
+	lagged bool
+}
+
+// Watch держит открытым SSE-поток и отправляет в него изменения ключей с
+// префиксом prefix. Ключ лежит на нескольких нодах, поэтому координатор
+// подписывается на все ноды кольца и убирает дубли по версии.
+func (h *Handler) Watch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events := make(chan watchMsg, watchEventBuffer)
+	go h.watchLocal(ctx, prefix, events)
+	go h.watchCluster(ctx, prefix, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	seen := newVersionSet(watchDedupCapacity)
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.closing:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case msg := <-events:
+			if msg.lagged {
+				// Часть событий потеряна - клиент должен перечитать состояние через /scan
+				fmt.Fprint(w, "event: error\ndata: subscription lagged behind\n\n")
+				flusher.Flush()
+				return
+			}
+			if !seen.add(msg.ev) {
+				continue
+			}
+			if err := writeWatchEvent(w, msg.ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// watchLocal пересылает изменения локального хранилища
+func (h *Handler) watchLocal(ctx context.Context, prefix string, out chan<- watchMsg) {
+	sub := h.store.Subscribe(prefix)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					select {
+					case out <- watchMsg{lagged: true}:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+			select {
+			case out <- watchMsg{ev: ev}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// watchCluster подписывается на остальные ноды кольца и следит за составом:
+// на новые ноды подписывается, подписки на ушедшие отменяет
+func (h *Handler) watchCluster(ctx context.Context, prefix string, out chan<- watchMsg) {
+	watching := make(map[hashring.NodeID]context.CancelFunc)
+	defer func() {
+		for _, stop := range watching {
+			stop()
+		}
+	}()
+
+	ticker := time.NewTicker(watchResyncNodes)
+	defer ticker.Stop()
+
+	for {
+		active := make(map[hashring.NodeID]bool)
+		for _, id := range h.ring.Nodes() {
+			if id == h.self {
+				continue
+			}
+			active[id] = true
+			if _, ok := watching[id]; !ok {
+				nodeCtx, stop := context.WithCancel(ctx)
+				watching[id] = stop
+				go h.watchRemote(nodeCtx, id, prefix, out)
+			}
+		}
+		for id, stop := range watching {
+			if !active[id] {
+				stop()
+				delete(watching, id)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchRemote читает поток изменений ноды и переподключается при обрыве.
+// Пока нода недоступна, события ее ключей приходят от других реплик.
+// Если подписка на ноде переполнилась, события потеряны: об этом сообщается
+// клиенту так же, как о переполнении локальной подписки.
+func (h *Handler) watchRemote(ctx context.Context, id hashring.NodeID, prefix string, out chan<- watchMsg) {
+	for {
+		addr, ok := h.ring.GetNodeAddr(id)
+		if ok {
+			err := h.peers.Watch(ctx, addr, prefix, func(ev kv.Event) {
+				select {
+				case out <- watchMsg{ev: ev}:
+				case <-ctx.Done():
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, peer.ErrLagged) {
+				select {
+				case out <- watchMsg{lagged: true}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			log.Printf("ERR: watch stream from %s broken: %v", id, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchReconnect):
+		}
+	}
+}
+
+// InternalWatch отдает поток изменений локального хранилища (одна JSON-строка
+// на событие). При переполнении подписки поток заканчивается отметкой lagged.
+func (h *Handler) InternalWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub := h.store.Subscribe(r.URL.Query().Get("prefix"))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closing:
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					_ = peer.WriteLagged(w)
+					flusher.Flush()
+				}
+				return
+			}
+			if err := peer.WriteEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// CloseStreams завершает открытые потоки /watch, чтобы остановка
+// HTTP-сервера не ждала их до таймаута
+func (h *Handler) CloseStreams() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+func writeWatchEvent(w http.ResponseWriter, ev kv.Event) error {
+	kind := "put"
+	item := scanResponseItem{Key: ev.Key, Version: ev.Entry.Version.String()}
+	if ev.Entry.Deleted {
+		kind = "delete"
+	} else {
+		item.Value = ev.Entry.Value
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", kind, item.Version, data)
+	return err
+}
+
+// versionSet помнит последние capacity событий, чтобы одно изменение,
+// пришедшее от нескольких реплик, отправлялось клиенту один раз
+type versionSet struct {
+	seen  map[versionKey]struct{}
+	order []versionKey
+	next  int
+}
+
+type versionKey struct {
+	key     string
+	version kv.Version
+	deleted bool
+}
+
+func newVersionSet(capacity int) *versionSet {
+	return &versionSet{
+		seen:  make(map[versionKey]struct{}, capacity),
+		order: make([]versionKey, 0, capacity),
+	}
+}
+
+// add возвращает false, если событие уже встречалось
+func (s *versionSet) add(ev kv.Event) bool {
+	k := versionKey{key: ev.Key, version: ev.Entry.Version, deleted: ev.Entry.Deleted}
+	if _, ok := s.seen[k]; ok {
+		return false
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, k)
+	} else {
+		delete(s.seen, s.order[s.next])
+		s.order[s.next] = k
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.seen[k] = struct{}{}
+	return true
+}
@@ -0,0 +1,74 @@
+package kv
+
+import (
+	"errors"
+	"time"
+)
+
+// CommandOp - операция команды
+type CommandOp string
+
+const (
+	OpPut    CommandOp = "put"
+	OpDelete CommandOp = "delete"
+	OpIncr   CommandOp = "incr"
+)
+
+// Command - изменение ключа, которое все реплики группы Raft применяют
+// в одном и том же порядке (режим consistency: raft)
+type Command struct {
+	Op        CommandOp `json:"op"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	Delta     int64     `json:"delta,omitempty"`
+	ExpiresAt int64     `json:"expires_at,omitempty"`
+	Cond      Condition `json:"cond"`
+	// Version - версия, назначенная лидером
+	Version Version `json:"version"`
+	// Now - время лидера в наносекундах Unix, по нему проверяются TTL
+	Now int64 `json:"now"`
+}
+
+// Apply применяет команду. Результат зависит только от команды и текущей
+// записи ключа, поэтому на всех репликах он одинаковый: условия и TTL
+// проверяются по времени лидера, а если версия команды не новее текущей
+// (часы нового лидера отстают), она увеличивается на логический шаг.
+// При невыполненном условии возвращает текущую запись и ErrPreconditionFailed.
+func (s *Store) Apply(cmd Command) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.engine.Get(cmd.Key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Entry{}, err
+	}
+	exists := err == nil
+	now := time.Unix(0, cmd.Now)
+	if !cmd.Cond.match(cur, exists, now) {
+		return copyEntry(cur), ErrPreconditionFailed
+	}
+
+	var e Entry
+	switch cmd.Op {
+	case OpPut:
+		e = Entry{Value: cmd.Value, ExpiresAt: cmd.ExpiresAt}
+	case OpDelete:
+		e = Entry{Deleted: true}
+	case OpIncr:
+		if e, _, err = increment(cur, exists, now, cmd.Delta, cmd.ExpiresAt); err != nil {
+			return Entry{}, err
+		}
+	default:
+		return Entry{}, errors.New("unknown command " + string(cmd.Op))
+	}
+
+	e.Version = cmd.Version
+	if exists && cur.Version.Compare(e.Version) >= 0 {
+		e.Version = Version{Wall: cur.Version.Wall, Logical: cur.Version.Logical + 1, Node: cmd.Version.Node}
+	}
+	if err := s.engine.Put(cmd.Key, copyEntry(e)); err != nil {
+		return Entry{}, err
+	}
+	s.publish(cmd.Key, e)
+	return copyEntry(e), nil
+}
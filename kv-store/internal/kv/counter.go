@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment would overflow")
+)
+
+// Incr атомарно прибавляет delta к целому значению ключа (десятичная строка)
+// и записывает результат с версией version. Отсутствующий, удаленный или
+// истекший ключ считается равным нулю. Срок жизни живого ключа сохраняется,
+// если expiresAt = 0. Если локальная версия не старше version, возвращается
+// ErrPreconditionFailed и вызывающий повторяет операцию с новой версией.
+func (s *Store) Incr(key string, delta int64, version Version, expiresAt int64) (Entry, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.lockedLocked(key, "", now) {
+		return Entry{}, 0, ErrLocked
+	}
+
+	cur, err := s.engine.Get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Entry{}, 0, err
+	}
+	exists := err == nil
+	if exists && cur.Version.Compare(version) >= 0 {
+		return copyEntry(cur), 0, ErrPreconditionFailed
+	}
+
+	e, n, err := increment(cur, exists, now, delta, expiresAt)
+	if err != nil {
+		return Entry{}, 0, err
+	}
+	e.Version = version
+	if err := s.engine.Put(key, copyEntry(e)); err != nil {
+		return Entry{}, 0, err
+	}
+	s.publish(key, e)
+	return e, n, nil
+}
+
+// increment вычисляет новую запись счетчика (без версии) по текущей
+func increment(cur Entry, exists bool, now time.Time, delta, expiresAt int64) (Entry, int64, error) {
+	var n int64
+	if exists && cur.live(now) {
+		var err error
+		n, err = strconv.ParseInt(strings.TrimSpace(string(cur.Value)), 10, 64)
+		if err != nil {
+			return Entry{}, 0, ErrNotInteger
+		}
+		if expiresAt == 0 {
+			expiresAt = cur.ExpiresAt
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return Entry{}, 0, ErrOverflow
+	}
+	n += delta
+	return Entry{Value: []byte(strconv.FormatInt(n, 10)), ExpiresAt: expiresAt}, n, nil
+}
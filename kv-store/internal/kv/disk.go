@@ -0,0 +1,132 @@
+package kv
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kv-store/internal/wal"
+)
+
+// diskEngine держит данные в памяти, но каждое изменение сначала пишет
+// в журнал (WAL), а периодически сохраняет снапшоты. При старте состояние
+// восстанавливается из последнего снапшота и хвоста журнала.
+type diskEngine struct {
+	mu   sync.RWMutex
+	data *sortedMap
+	wal  *wal.Log
+
+	dataDir    string
+	snapMu     sync.Mutex
+	snapSeq    uint64
+	snapRetain int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func openDiskEngine(opts Options) (*diskEngine, error) {
+	d := &diskEngine{
+		data:       newSortedMap(),
+		dataDir:    opts.DataDir,
+		snapRetain: opts.SnapshotRetain,
+		done:       make(chan struct{}),
+	}
+	if err := os.MkdirAll(opts.DataDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	snapSeq, err := d.loadSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+	d.snapSeq = snapSeq
+
+	journal, err := wal.Open(wal.Options{
+		Dir:          filepath.Join(opts.DataDir, "wal"),
+		Sync:         opts.Sync,
+		SyncInterval: opts.SyncInterval,
+		StartSeq:     snapSeq,
+	}, d.replay)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	d.wal = journal
+
+	log.Printf("Store recovered %d keys (snapshot seq %d, WAL seq %d) in %v", d.data.len(), snapSeq, journal.LastSeq(), time.Since(start))
+
+	if opts.SnapshotInterval > 0 {
+		d.wg.Add(1)
+		go d.snapshotLoop(opts.SnapshotInterval)
+	}
+	return d, nil
+}
+
+func (d *diskEngine) Get(key string) (Entry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	e, ok := d.data.get(key)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+// Put пишет запись в журнал и только после этого - в память
+func (d *diskEngine) Put(key string, e Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.wal.Append(encodeRecord(opPut, key, e)); err != nil {
+		return err
+	}
+	d.data.set(key, e)
+	return nil
+}
+
+func (d *diskEngine) Delete(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, exists := d.data.get(key); !exists {
+		return nil
+	}
+	if _, err := d.wal.Append(encodeRecord(opDelete, key, Entry{})); err != nil {
+		return err
+	}
+	d.data.delete(key)
+	return nil
+}
+
+func (d *diskEngine) Scan(from string, fn func(key string, e Entry) bool) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	d.data.ascend(from, fn)
+	return nil
+}
+
+// Close останавливает фоновые снапшоты, сбрасывает журнал на диск и закрывает его
+func (d *diskEngine) Close() error {
+	close(d.done)
+	d.wg.Wait()
+	return d.wal.Close()
+}
+
+// replay применяет запись журнала при восстановлении
+func (d *diskEngine) replay(_ uint64, payload []byte) error {
+	op, key, e, err := decodeRecord(payload)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case opPut:
+		d.data.set(key, e)
+	case opDelete:
+		d.data.delete(key)
+	}
+	return nil
+}
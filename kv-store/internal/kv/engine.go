@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"fmt"
+	"time"
+
+	"kv-store/internal/wal"
+)
+
+// Engine - движок хранения записей, поверх которого работает Store.
+// Store отвечает за версии и атомарность условных записей, а движок -
+// только за то, где и как лежат данные. Реализации должны быть
+// безопасны для конкурентного использования.
+type Engine interface {
+	// Get возвращает запись (в том числе tombstone) или ErrNotFound
+	Get(key string) (Entry, error)
+	// Put сохраняет запись, перезаписывая текущую
+	Put(key string, e Entry) error
+	// Delete физически удаляет ключ
+	Delete(key string) error
+	// Scan обходит записи с ключами >= from в порядке возрастания,
+	// пока fn возвращает true. fn не должна обращаться к движку.
+	Scan(from string, fn func(key string, e Entry) bool) error
+	// Snapshot фиксирует текущее состояние на диске, если движок это умеет
+	Snapshot() error
+	Close() error
+}
+
+// Имена движков для storage.engine в конфиге
+const (
+	EngineMemory = "memory"
+	EngineDisk   = "disk"
+	EngineLSM    = "lsm"
+)
+
+// Options - настройки движка хранения
+type Options struct {
+	// Engine - имя движка; по умолчанию memory
+	Engine string
+
+	// DataDir - каталог для журнала и снапшотов дисковых движков
+	DataDir      string
+	Sync         wal.SyncPolicy
+	SyncInterval time.Duration
+
+	// SnapshotInterval - период снапшотов; 0 - снапшоты не делаются
+	SnapshotInterval time.Duration
+	// SnapshotRetain - сколько последних снапшотов хранить на диске
+	SnapshotRetain int
+
+	// MemtableSize - размер memtable движка lsm в байтах
+	MemtableSize int
+}
+
+// OpenEngine создает движок, выбранный в opts.Engine
+func OpenEngine(opts Options) (Engine, error) {
+	switch opts.Engine {
+	case "", EngineMemory:
+		return newMemoryEngine(), nil
+	case EngineDisk:
+		if opts.DataDir == "" {
+			return nil, fmt.Errorf("engine %q requires a data dir", opts.Engine)
+		}
+		return openDiskEngine(opts)
+	case EngineLSM:
+		if opts.DataDir == "" {
+			return nil, fmt.Errorf("engine %q requires a data dir", opts.Engine)
+		}
+		return openLSMEngine(opts)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", opts.Engine)
+	}
+}
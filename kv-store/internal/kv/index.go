@@ -0,0 +1,105 @@
+package kv
+
+import (
+	"math/rand"
+	"time"
+)
+
+const indexMaxHeight = 20
+
+// sortedMap - записи в map для поиска по ключу и в skiplist для обхода
+// по возрастанию ключей. Не потокобезопасна: доступ защищает движок.
+type sortedMap struct {
+	items  map[string]*indexNode
+	head   *indexNode
+	height int
+	rnd    *rand.Rand
+}
+
+type indexNode struct {
+	key   string
+	entry Entry
+	next  []*indexNode
+}
+
+func newSortedMap() *sortedMap {
+	return &sortedMap{
+		items:  make(map[string]*indexNode),
+		head:   &indexNode{next: make([]*indexNode, indexMaxHeight)},
+		height: 1,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (m *sortedMap) len() int {
+	return len(m.items)
+}
+
+func (m *sortedMap) get(key string) (Entry, bool) {
+	n, ok := m.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return n.entry, true
+}
+
+func (m *sortedMap) set(key string, e Entry) {
+	if n, ok := m.items[key]; ok {
+		n.entry = e
+		return
+	}
+
+	prev := m.findPrev(key)
+	h := 1
+	for h < indexMaxHeight && m.rnd.Intn(4) == 0 {
+		h++
+	}
+	if h > m.height {
+		for i := m.height; i < h; i++ {
+			prev[i] = m.head
+		}
+		m.height = h
+	}
+
+	n := &indexNode{key: key, entry: e, next: make([]*indexNode, h)}
+	for i := 0; i < h; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	m.items[key] = n
+}
+
+func (m *sortedMap) delete(key string) {
+	n, ok := m.items[key]
+	if !ok {
+		return
+	}
+	prev := m.findPrev(key)
+	for i := range n.next {
+		prev[i].next[i] = n.next[i]
+	}
+	delete(m.items, key)
+}
+
+// ascend обходит записи с ключами >= from по возрастанию, пока fn возвращает true
+func (m *sortedMap) ascend(from string, fn func(key string, e Entry) bool) {
+	prev := m.findPrev(from)
+	for n := prev[0].next[0]; n != nil; n = n.next[0] {
+		if !fn(n.key, n.entry) {
+			return
+		}
+	}
+}
+
+// findPrev возвращает на каждом уровне последний узел с ключом < key
+func (m *sortedMap) findPrev(key string) []*indexNode {
+	prev := make([]*indexNode, indexMaxHeight)
+	x := m.head
+	for lvl := m.height - 1; lvl >= 0; lvl-- {
+		for x.next[lvl] != nil && x.next[lvl].key < key {
+			x = x.next[lvl]
+		}
+		prev[lvl] = x
+	}
+	return prev
+}
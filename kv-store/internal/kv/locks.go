@@ -0,0 +1,89 @@
+package kv
+
+import "time"
+
+// keyLock - блокировка ключа подготовленной транзакцией
+type keyLock struct {
+	owner    string
+	deadline time.Time
+}
+
+// LockKeys блокирует ключи за транзакцией owner на время ttl. Блокировка
+// всех ключей атомарна: если хотя бы один занят другой транзакцией,
+// не блокируется ни один и возвращается ErrLocked. Повторный вызов
+// тем же owner продлевает блокировку.
+//
+// Блокировки держатся только в памяти и снимаются сами по истечении ttl,
+// чтобы упавший координатор не оставил ключи заблокированными навсегда.
+func (s *Store) LockKeys(owner string, keys []string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if s.lockedLocked(key, owner, now) {
+			return ErrLocked
+		}
+	}
+
+	deadline := now.Add(ttl)
+	for _, key := range keys {
+		if l, ok := s.locks[key]; !ok || l.owner != owner {
+			s.owned[owner] = append(s.owned[owner], key)
+		}
+		s.locks[key] = keyLock{owner: owner, deadline: deadline}
+	}
+	return nil
+}
+
+// UnlockKeys снимает все блокировки транзакции owner
+func (s *Store) UnlockKeys(owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.owned[owner] {
+		if l, ok := s.locks[key]; ok && l.owner == owner {
+			delete(s.locks, key)
+		}
+	}
+	delete(s.owned, owner)
+}
+
+// lockedLocked сообщает, заблокирован ли ключ транзакцией, отличной от owner.
+// Истекшие блокировки попутно удаляются. Вызывается под s.mu.Lock.
+func (s *Store) lockedLocked(key, owner string, now time.Time) bool {
+	l, ok := s.locks[key]
+	if !ok {
+		return false
+	}
+	if now.After(l.deadline) {
+		delete(s.locks, key)
+		return false
+	}
+	return l.owner != owner
+}
+
+// expireLocks удаляет истекшие блокировки транзакций, которые так и не завершились
+func (s *Store) expireLocks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for owner, keys := range s.owned {
+		alive := false
+		for _, key := range keys {
+			l, ok := s.locks[key]
+			if !ok || l.owner != owner {
+				continue
+			}
+			if now.After(l.deadline) {
+				delete(s.locks, key)
+			} else {
+				alive = true
+			}
+		}
+		if !alive {
+			delete(s.owned, owner)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPutUnlockedRespectsTransactionLocks(t *testing.T) {
+	s := NewStore(newMemoryEngine())
+	defer s.Close()
+	clock := NewClock("n1")
+
+	if err := s.LockKeys("tx1", []string{"a"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.PutUnlocked("a", Entry{Value: []byte("1"), Version: clock.Now()}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("PutUnlocked on locked key: err = %v, want ErrLocked", err)
+	}
+	if _, err := s.PutUnlocked("b", Entry{Value: []byte("1"), Version: clock.Now()}); err != nil {
+		t.Fatalf("PutUnlocked on free key: %v", err)
+	}
+
+	// Запись самой транзакции и реплик блокировки не проверяет
+	if ok, err := s.PutEntry("a", Entry{Value: []byte("tx"), Version: clock.Now()}); err != nil || !ok {
+		t.Fatalf("PutEntry on locked key = %v, %v", ok, err)
+	}
+
+	s.UnlockKeys("tx1")
+	if ok, err := s.PutUnlocked("a", Entry{Value: []byte("2"), Version: clock.Now()}); err != nil || !ok {
+		t.Fatalf("PutUnlocked after unlock = %v, %v", ok, err)
+	}
+}
+
+func TestLocksExpire(t *testing.T) {
+	s := NewStore(newMemoryEngine())
+	defer s.Close()
+
+	if err := s.LockKeys("tx1", []string{"a", "b"}, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LockKeys("tx2", []string{"b", "c"}, time.Minute); !errors.Is(err, ErrLocked) {
+		t.Fatalf("LockKeys on locked key: err = %v, want ErrLocked", err)
+	}
+	// Неудачная попытка не блокирует ни одного ключа
+	if _, err := s.PutUnlocked("c", Entry{Value: []byte("1"), Version: NewClock("n1").Now()}); err != nil {
+		t.Fatalf("key c was locked by failed LockKeys: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := s.LockKeys("tx2", []string{"b", "c"}, time.Minute); err != nil {
+		t.Fatalf("LockKeys after expiry: %v", err)
+	}
+}
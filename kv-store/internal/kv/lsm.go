@@ -0,0 +1,71 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+
+	"kv-store/internal/lsm"
+)
+
+// lsmEngine хранит записи в LSM-дереве на диске, поэтому объем данных
+// не ограничен памятью. Версия и признак tombstone лежат в значении.
+type lsmEngine struct {
+	db *lsm.DB
+}
+
+func openLSMEngine(opts Options) (*lsmEngine, error) {
+	db, err := lsm.Open(lsm.Options{
+		Dir:          filepath.Join(opts.DataDir, "lsm"),
+		Sync:         opts.Sync,
+		SyncInterval: opts.SyncInterval,
+		MemtableSize: opts.MemtableSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lsmEngine{db: db}, nil
+}
+
+func (l *lsmEngine) Get(key string) (Entry, error) {
+	raw, err := l.db.Get(key)
+	if errors.Is(err, lsm.ErrNotFound) {
+		return Entry{}, ErrNotFound
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return decodeEntry(raw)
+}
+
+func (l *lsmEngine) Put(key string, e Entry) error {
+	return l.db.Put(key, encodeEntry(e))
+}
+
+func (l *lsmEngine) Delete(key string) error {
+	return l.db.Delete(key)
+}
+
+func (l *lsmEngine) Scan(from string, fn func(key string, e Entry) bool) error {
+	var decodeErr error
+	err := l.db.Scan(from, func(key string, raw []byte) bool {
+		e, err := decodeEntry(raw)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(key, e)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// Snapshot сбрасывает memtable в SSTable, после чего журнал укорачивается
+func (l *lsmEngine) Snapshot() error {
+	return l.db.Flush()
+}
+
+func (l *lsmEngine) Close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Machine - Store в роли конечного автомата групп Raft. Группа владеет
+// ключами своего шарда: shardOf(key) == номер группы.
+type Machine struct {
+	store   *Store
+	shardOf func(key string) int
+}
+
+// CommandResult - результат применения команды группы
+type CommandResult struct {
+	Entry Entry
+	Err   error
+}
+
+func NewMachine(store *Store, shardOf func(key string) int) *Machine {
+	return &Machine{store: store, shardOf: shardOf}
+}
+
+// Apply применяет команду (JSON Command) и возвращает CommandResult
+func (m *Machine) Apply(_ int, data []byte) interface{} {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return CommandResult{Err: fmt.Errorf("decode command: %w", err)}
+	}
+	e, err := m.store.Apply(cmd)
+	return CommandResult{Entry: e, Err: err}
+}
+
+// Snapshot сериализует все записи шарда, включая tombstone
+func (m *Machine) Snapshot(group int) ([]byte, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	items := []Item{}
+	err := m.store.engine.Scan("", func(key string, e Entry) bool {
+		if m.shardOf(key) == group {
+			items = append(items, Item{Key: key, Entry: e})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(items)
+}
+
+// Restore заменяет записи шарда содержимым снапшота. Подписчикам /watch
+// изменения не рассылаются: их уже прислал лидер группы.
+func (m *Machine) Restore(group int, data []byte) error {
+	var items []Item
+	if data != nil {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return fmt.Errorf("decode snapshot: %w", err)
+		}
+	}
+	keep := make(map[string]bool, len(items))
+	for _, it := range items {
+		keep[it.Key] = true
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var stale []string
+	err := m.store.engine.Scan("", func(key string, _ Entry) bool {
+		if m.shardOf(key) == group && !keep[key] {
+			stale = append(stale, key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := m.store.engine.Delete(key); err != nil {
+			return err
+		}
+	}
+	for _, it := range items {
+		if err := m.store.engine.Put(it.Key, it.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
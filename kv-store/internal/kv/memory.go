@@ -0,0 +1,48 @@
+package kv
+
+import "sync"
+
+// memoryEngine хранит записи только в памяти: данные пропадают при рестарте
+type memoryEngine struct {
+	mu   sync.RWMutex
+	data *sortedMap
+}
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{data: newSortedMap()}
+}
+
+func (m *memoryEngine) Get(key string) (Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.data.get(key)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+func (m *memoryEngine) Put(key string, e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.set(key, e)
+	return nil
+}
+
+func (m *memoryEngine) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.delete(key)
+	return nil
+}
+
+func (m *memoryEngine) Scan(from string, fn func(key string, e Entry) bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	m.data.ascend(from, fn)
+	return nil
+}
+
+func (m *memoryEngine) Snapshot() error { return nil }
+
+func (m *memoryEngine) Close() error { return nil }
@@ -0,0 +1,152 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Операции, которые Store пишет в журнал
+const (
+	opPut    byte = 1 // запись значения или tombstone
+	opDelete byte = 2 // физическое удаление ключа
+)
+
+// Флаги записи
+const (
+	flagDeleted byte = 1 << iota
+	flagExpires      // за флагами следует ExpiresAt
+)
+
+var errBadRecord = errors.New("kv: malformed log record")
+
+// encodeRecord сериализует операцию журнала: op | len(key) key | entry
+func encodeRecord(op byte, key string, e Entry) []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+entrySize(e))
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	if op == opDelete {
+		return buf
+	}
+	return appendEntry(buf, e)
+}
+
+func decodeRecord(buf []byte) (op byte, key string, e Entry, err error) {
+	r := recordReader{buf: buf}
+	op = r.byte()
+	key = string(r.bytes(int(r.uvarint())))
+	if r.err != nil {
+		return 0, "", Entry{}, r.err
+	}
+	if op == opDelete {
+		return op, key, Entry{}, nil
+	}
+	if op != opPut {
+		return 0, "", Entry{}, errBadRecord
+	}
+
+	e, err = decodeEntry(r.buf)
+	if err != nil {
+		return 0, "", Entry{}, err
+	}
+	return op, key, e, nil
+}
+
+// encodeEntry сериализует запись без ключа: flags | [expires] | wall | logical | len(node) node | value
+func encodeEntry(e Entry) []byte {
+	return appendEntry(make([]byte, 0, entrySize(e)), e)
+}
+
+func appendEntry(buf []byte, e Entry) []byte {
+	var flags byte
+	if e.Deleted {
+		flags |= flagDeleted
+	}
+	if e.ExpiresAt != 0 {
+		flags |= flagExpires
+	}
+	buf = append(buf, flags)
+	if e.ExpiresAt != 0 {
+		buf = binary.AppendVarint(buf, e.ExpiresAt)
+	}
+	buf = binary.AppendVarint(buf, e.Version.Wall)
+	buf = binary.AppendUvarint(buf, uint64(e.Version.Logical))
+	buf = binary.AppendUvarint(buf, uint64(len(e.Version.Node)))
+	buf = append(buf, e.Version.Node...)
+	return append(buf, e.Value...)
+}
+
+func decodeEntry(buf []byte) (Entry, error) {
+	r := recordReader{buf: buf}
+	var e Entry
+	flags := r.byte()
+	e.Deleted = flags&flagDeleted != 0
+	if flags&flagExpires != 0 {
+		e.ExpiresAt = r.varint()
+	}
+	e.Version.Wall = r.varint()
+	e.Version.Logical = uint32(r.uvarint())
+	e.Version.Node = string(r.bytes(int(r.uvarint())))
+	if r.err != nil {
+		return Entry{}, r.err
+	}
+	if !e.Deleted {
+		e.Value = append([]byte{}, r.buf...)
+	}
+	return e, nil
+}
+
+func entrySize(e Entry) int {
+	return 1 + 4*binary.MaxVarintLen64 + len(e.Version.Node) + len(e.Value)
+}
+
+type recordReader struct {
+	buf []byte
+	err error
+}
+
+func (r *recordReader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errBadRecord
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *recordReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errBadRecord
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *recordReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errBadRecord
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *recordReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errBadRecord
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
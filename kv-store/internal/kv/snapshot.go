@@ -0,0 +1,265 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Формат снапшота: magic | seq (8 байт) | число записей (8 байт) |
+// записи в том же виде, что и в журнале: [длина][crc32c][payload]
+const (
+	snapshotMagic  = "KVSNAP1\n"
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+)
+
+var (
+	errBadSnapshot = errors.New("kv: corrupt snapshot")
+
+	snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type snapshotFile struct {
+	path string
+	seq  uint64
+}
+
+// Snapshot атомарно сохраняет текущее состояние движка на диск
+// и удаляет из журнала записи, которые больше не нужны для восстановления.
+func (d *diskEngine) Snapshot() error {
+	d.snapMu.Lock()
+	defer d.snapMu.Unlock()
+
+	// Под блокировкой на чтение мутации не идут, поэтому копия карты
+	// в точности соответствует журналу до seq. Значения неизменяемы,
+	// копировать их не нужно.
+	d.mu.RLock()
+	seq := d.wal.LastSeq()
+	if seq == d.snapSeq {
+		d.mu.RUnlock()
+		return nil
+	}
+	data := make(map[string]Entry, d.data.len())
+	d.data.ascend("", func(k string, e Entry) bool {
+		data[k] = e
+		return true
+	})
+	err := d.wal.Roll()
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := writeSnapshot(d.dataDir, seq, data); err != nil {
+		return err
+	}
+	d.snapSeq = seq
+
+	oldest, err := d.pruneSnapshots()
+	if err != nil {
+		log.Printf("ERR: Failed to prune snapshots: %v", err)
+	}
+	removed, err := d.wal.TruncateBefore(oldest)
+	if err != nil {
+		log.Printf("ERR: Failed to truncate WAL: %v", err)
+	}
+
+	log.Printf("Snapshot at seq %d: %d keys in %v, removed %d WAL segments", seq, len(data), time.Since(start), removed)
+	return nil
+}
+
+// snapshotLoop периодически делает снапшоты, пока движок не закрыт
+func (d *diskEngine) snapshotLoop(interval time.Duration) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			if err := d.Snapshot(); err != nil {
+				log.Printf("ERR: Snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// pruneSnapshots оставляет snapRetain последних снапшотов
+// и возвращает seq самого старого из оставшихся
+func (d *diskEngine) pruneSnapshots() (uint64, error) {
+	snaps, err := listSnapshots(d.dataDir)
+	if err != nil || len(snaps) == 0 {
+		return 0, err
+	}
+
+	keep := d.snapRetain
+	if keep < 1 {
+		keep = 1
+	}
+	if len(snaps) > keep {
+		for _, snap := range snaps[keep:] {
+			if err := os.Remove(snap.path); err != nil {
+				return 0, err
+			}
+		}
+		snaps = snaps[:keep]
+	}
+	return snaps[len(snaps)-1].seq, nil
+}
+
+// loadSnapshot загружает самый свежий целый снапшот и возвращает его seq.
+// Поврежденные снапшоты пропускаются.
+func (d *diskEngine) loadSnapshot() (uint64, error) {
+	snaps, err := listSnapshots(d.dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, snap := range snaps {
+		data, err := readSnapshot(snap.path, snap.seq)
+		if err != nil {
+			log.Printf("ERR: Skipping snapshot %s: %v", filepath.Base(snap.path), err)
+			continue
+		}
+		d.data = data
+		return snap.seq, nil
+	}
+	return 0, nil
+}
+
+func writeSnapshot(dir string, seq uint64, data map[string]Entry) error {
+	final := filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotSuffix))
+	tmp := final + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	var header [16]byte
+	binary.LittleEndian.PutUint64(header[0:8], seq)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(data)))
+	w.WriteString(snapshotMagic)
+	w.Write(header[:])
+
+	var frame [8]byte
+	for k, e := range data {
+		payload := encodeRecord(opPut, k, e)
+		binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+		binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, snapshotCRC))
+		w.Write(frame[:])
+		if _, err := w.Write(payload); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func readSnapshot(path string, seq uint64) (*sortedMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	head := make([]byte, len(snapshotMagic)+16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, errBadSnapshot
+	}
+	if string(head[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errBadSnapshot
+	}
+	if binary.LittleEndian.Uint64(head[len(snapshotMagic):]) != seq {
+		return nil, errBadSnapshot
+	}
+	count := binary.LittleEndian.Uint64(head[len(snapshotMagic)+8:])
+
+	data := newSortedMap()
+	var frame [8]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			return nil, errBadSnapshot
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(frame[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, errBadSnapshot
+		}
+		if crc32.Checksum(payload, snapshotCRC) != binary.LittleEndian.Uint32(frame[4:8]) {
+			return nil, errBadSnapshot
+		}
+		op, key, e, err := decodeRecord(payload)
+		if err != nil || op != opPut {
+			return nil, errBadSnapshot
+		}
+		data.set(key, e)
+	}
+	return data, nil
+}
+
+// listSnapshots возвращает снапшоты из dir, от новых к старым
+func listSnapshots(dir string) ([]snapshotFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snaps []snapshotFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snapshotFile{path: filepath.Join(dir, name), seq: seq})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].seq > snaps[j].seq })
+	return snaps, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -3,68 +3,257 @@ package kv
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
-var ErrNotFound = errors.New("key not found")
+var (
+	ErrNotFound           = errors.New("key not found")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrLocked             = errors.New("key is locked by a transaction")
+)
+
+// Entry - значение ключа вместе с его версией.
+// Удаление хранится как tombstone (Deleted = true), чтобы реплики
+// и миграция не воскрешали удаленный ключ более старой версией.
+type Entry struct {
+	Value   []byte
+	Version Version
+	Deleted bool
+	// ExpiresAt - момент истечения TTL в наносекундах Unix; 0 - без TTL
+	ExpiresAt int64
+}
+
+// Expired сообщает, истек ли TTL записи к моменту now
+func (e Entry) Expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && now.UnixNano() >= e.ExpiresAt
+}
 
+// live - запись существует для клиента: не удалена и не истекла
+func (e Entry) live(now time.Time) bool {
+	return !e.Deleted && !e.Expired(now)
+}
+
+// Store - версионированное хранилище ноды поверх движка Engine.
+// Сравнение версий и условные записи выполняются атомарно под mu.
 type Store struct {
-	mu   sync.RWMutex
-	data map[string][]byte
+	mu     sync.RWMutex
+	engine Engine
+	locks  map[string]keyLock
+	owned  map[string][]string
+
+	subsMu sync.Mutex
+	subs   map[*Subscription]struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
 }
 
-func NewStore() *Store {
-	return &Store{
-		data: make(map[string][]byte),
+// NewStore создает хранилище и запускает фоновую очистку истекших записей
+func NewStore(engine Engine) *Store {
+	s := &Store{
+		engine: engine,
+		locks:  make(map[string]keyLock),
+		owned:  make(map[string][]string),
+		subs:   make(map[*Subscription]struct{}),
+		done:   make(chan struct{}),
 	}
+	s.wg.Add(1)
+	go s.sweepLoop()
+	return s
 }
 
-func (s *Store) Put(key string, value []byte) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	valCopy := make([]byte, len(value))
-	copy(valCopy, value)
-	s.data[key] = valCopy
+// Close останавливает очистку и закрывает движок хранения
+func (s *Store) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.engine.Close()
 }
 
+// Snapshot просит движок зафиксировать текущее состояние на диске
+func (s *Store) Snapshot() error {
+	return s.engine.Snapshot()
+}
+
+// Get возвращает текущее значение ключа. Удаленный или истекший ключ считается отсутствующим.
 func (s *Store) Get(key string) ([]byte, error) {
+	e, err := s.GetEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	if !e.live(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return e.Value, nil
+}
+
+// GetEntry возвращает копию записи вместе с версией, в том числе tombstone
+func (s *Store) GetEntry(key string) (Entry, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, ok := s.data[key]
-	if !ok {
-		return nil, ErrNotFound
+	e, err := s.engine.Get(key)
+	if err != nil {
+		return Entry{}, err
 	}
-	ret := make([]byte, len(val))
-	copy(ret, val)
-	return ret, nil
+	return copyEntry(e), nil
 }
 
-func (s *Store) Delete(key string) {
+// PutEntry записывает значение или tombstone, только если его версия новее
+// локальной. Возвращает true, если запись применена.
+func (s *Store) PutEntry(key string, e Entry) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.data, key)
+	return s.putLocked(key, e)
 }
 
-func (s *Store) KeysSnapshot() []string {
+// PutUnlocked - PutEntry для записи клиента на владельце ключа: если ключ
+// заблокирован подготовленной транзакцией, запись не применяется и
+// возвращается ErrLocked
+func (s *Store) PutUnlocked(key string, e Entry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lockedLocked(key, "", time.Now()) {
+		return false, ErrLocked
+	}
+	return s.putLocked(key, e)
+}
+
+// putLocked применяет запись, если ее версия новее локальной. Вызывается под s.mu.Lock.
+func (s *Store) putLocked(key string, e Entry) (bool, error) {
+	cur, err := s.engine.Get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err == nil && cur.Version.Compare(e.Version) >= 0 {
+		return false, nil
+	}
+	if err := s.engine.Put(key, copyEntry(e)); err != nil {
+		return false, err
+	}
+	s.publish(key, e)
+	return true, nil
+}
+
+// Condition - условие атомарной записи (аналог If-Match / If-None-Match)
+type Condition struct {
+	Version   Version // текущая версия должна совпадать с указанной
+	Exists    bool    // ключ должен существовать (If-Match: *)
+	NotExists bool    // ключа быть не должно (If-None-Match: *)
+}
+
+func (c Condition) IsZero() bool {
+	return c.Version.IsZero() && !c.Exists && !c.NotExists
+}
+
+// match проверяет условие по текущей записи ключа
+func (c Condition) match(cur Entry, exists bool, now time.Time) bool {
+	live := exists && cur.live(now)
+	switch {
+	case c.NotExists && live,
+		c.Exists && !live,
+		!c.Version.IsZero() && (!live || cur.Version.Compare(c.Version) != 0):
+		return false
+	}
+	return true
+}
+
+// CompareAndPut атомарно проверяет условие по локальной записи и применяет e
+// (значение или tombstone). Tombstone и истекшая запись считаются отсутствующим ключом.
+// При невыполненном условии возвращает текущую запись и ErrPreconditionFailed,
+// а если ключ заблокирован транзакцией - ErrLocked.
+func (s *Store) CompareAndPut(key string, cond Condition, e Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lockedLocked(key, "", time.Now()) {
+		return Entry{}, ErrLocked
+	}
+
+	cur, err := s.engine.Get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Entry{}, err
+	}
+	exists := err == nil
+	if !cond.match(cur, exists, time.Now()) {
+		return copyEntry(cur), ErrPreconditionFailed
+	}
+
+	if exists && cur.Version.Compare(e.Version) >= 0 {
+		return copyEntry(cur), ErrPreconditionFailed
+	}
+	if err := s.engine.Put(key, copyEntry(e)); err != nil {
+		return Entry{}, err
+	}
+	s.publish(key, e)
+	return copyEntry(e), nil
+}
+
+// Check проверяет условие по локальной записи, ничего не записывая.
+// Возвращает текущую запись (если она есть) и ErrPreconditionFailed,
+// если условие не выполнено.
+func (s *Store) Check(key string, cond Condition) (Entry, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	keys := make([]string, 0, len(s.data))
-	for k := range s.data {
-		keys = append(keys, k)
+	cur, err := s.engine.Get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Entry{}, err
 	}
-	return keys
+	if !cond.match(cur, err == nil, time.Now()) {
+		return copyEntry(cur), ErrPreconditionFailed
+	}
+	return copyEntry(cur), nil
 }
 
-func (s *Store) PutIfNotExists(key string, value []byte) bool {
+// Delete физически удаляет ключ из локального хранилища (без tombstone).
+// Используется, когда нода перестает быть репликой ключа.
+func (s *Store) Delete(key string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.engine.Delete(key)
+}
 
-	if _, exists := s.data[key]; exists {
-		return false
-	}
+// Item - ключ вместе с записью (результат Scan)
+type Item struct {
+	Key   string
+	Entry Entry
+}
 
-	valCopy := make([]byte, len(value))
-	copy(valCopy, value)
-	s.data[key] = valCopy
-	return true
+// Scan возвращает до limit записей с ключами из [from, end) по возрастанию,
+// включая tombstone и истекшие, чтобы координатор мог сравнить версии реплик.
+// Пустой end - без верхней границы.
+func (s *Store) Scan(from, end string, limit int) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var items []Item
+	err := s.engine.Scan(from, func(key string, e Entry) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		items = append(items, Item{Key: key, Entry: copyEntry(e)})
+		return len(items) < limit
+	})
+	return items, err
+}
+
+// KeysSnapshot возвращает все локальные ключи, включая tombstone.
+// Обход идет без s.mu: движок сам безопасен для конкурентного доступа,
+// а полный обход не должен останавливать запись.
+func (s *Store) KeysSnapshot() []string {
+	var keys []string
+	s.engine.Scan("", func(key string, _ Entry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func copyEntry(e Entry) Entry {
+	if e.Value != nil {
+		valCopy := make([]byte, len(e.Value))
+		copy(valCopy, e.Value)
+		e.Value = valCopy
+	}
+	return e
 }
@@ -0,0 +1,128 @@
+package kv
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// sweepInterval - как часто искать записи с истекшим TTL
+	sweepInterval = time.Minute
+
+	// reclaimGrace - запас на расхождение часов нод: в режиме raft истечение
+	// проверяется по часам лидера, и реплика не должна удалить значение раньше
+	reclaimGrace = time.Minute
+
+	// tombstoneGrace - сколько хранить tombstone перед физическим удалением ключа.
+	// За это время удаление должно дойти до всех реплик (подсказки, read repair,
+	// ребалансировка); нода, выключенная дольше, может вернуть удаленные ключи.
+	tombstoneGrace = 24 * time.Hour
+)
+
+func (s *Store) sweepLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.expireLocks()
+			reclaimed, purged, err := s.Sweep()
+			if err != nil {
+				log.Printf("ERR: TTL sweep failed: %v", err)
+			} else if reclaimed > 0 || purged > 0 {
+				log.Printf("TTL sweep: reclaimed %d expired keys, purged %d tombstones", reclaimed, purged)
+			}
+		}
+	}
+}
+
+// Sweep заменяет записи, истекшие больше reclaimGrace назад, на tombstone
+// с той же версией и освобождает место под значения. Tombstone нужен, чтобы
+// отставшая реплика не вернула более старую версию без TTL, поэтому ключ
+// удаляется физически, только когда tombstone старше tombstoneGrace.
+// Возвращает, сколько записей заменено на tombstone и сколько ключей удалено.
+func (s *Store) Sweep() (reclaimed, purged int, err error) {
+	now := time.Now()
+	expiredBefore := now.Add(-reclaimGrace)
+	deletedBefore := now.Add(-tombstoneGrace)
+
+	var expired, stale []string
+	err = s.engine.Scan("", func(key string, e Entry) bool {
+		switch {
+		case !e.Deleted && e.Expired(expiredBefore):
+			expired = append(expired, key)
+		case e.Deleted && deletedAt(e).Before(deletedBefore):
+			stale = append(stale, key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, key := range expired {
+		ok, err := s.reclaim(key, expiredBefore)
+		if err != nil {
+			return reclaimed, purged, err
+		}
+		if ok {
+			reclaimed++
+		}
+	}
+	for _, key := range stale {
+		ok, err := s.purge(key, deletedBefore)
+		if err != nil {
+			return reclaimed, purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+	return reclaimed, purged, nil
+}
+
+// deletedAt - когда ключ перестал существовать: момент удаления (по версии)
+// или истечения TTL, если запись заменена на tombstone при очистке
+func deletedAt(e Entry) time.Time {
+	at := e.Version.Wall
+	if e.ExpiresAt > at {
+		at = e.ExpiresAt
+	}
+	return time.Unix(0, at)
+}
+
+// reclaim перепроверяет запись под блокировкой: за время обхода ее могли перезаписать
+func (s *Store) reclaim(key string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.engine.Get(key)
+	if err != nil || cur.Deleted || !cur.Expired(now) {
+		return false, nil
+	}
+	tombstone := Entry{Version: cur.Version, Deleted: true, ExpiresAt: cur.ExpiresAt}
+	if err := s.engine.Put(key, tombstone); err != nil {
+		return false, err
+	}
+	s.publish(key, tombstone)
+	return true, nil
+}
+
+// purge физически удаляет ключ, если под блокировкой он все еще tombstone старше before
+func (s *Store) purge(key string, before time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.engine.Get(key)
+	if err != nil || !cur.Deleted || !deletedAt(cur).Before(before) {
+		return false, nil
+	}
+	if err := s.engine.Delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSweepReclaimsExpiredAndPurgesTombstones(t *testing.T) {
+	s := NewStore(newMemoryEngine())
+	defer s.Close()
+
+	now := time.Now()
+	old := Version{Wall: now.Add(-2 * tombstoneGrace).UnixNano(), Node: "n1"}
+	fresh := Version{Wall: now.UnixNano(), Node: "n1"}
+
+	entries := map[string]Entry{
+		// Истек давно: заменяется на tombstone
+		"expired": {Value: []byte("v"), Version: fresh, ExpiresAt: now.Add(-2 * reclaimGrace).UnixNano()},
+		// Истек только что: еще в пределах reclaimGrace
+		"just-expired": {Value: []byte("v"), Version: fresh, ExpiresAt: now.Add(-time.Second).UnixNano()},
+		// Удален давно: удаляется физически
+		"old-tombstone": {Version: old, Deleted: true},
+		// Удален недавно: tombstone остается
+		"new-tombstone": {Version: fresh, Deleted: true},
+		// Записан давно, но истек недавно: tombstone отсчитывается от истечения
+		"expired-tombstone": {Version: old, Deleted: true, ExpiresAt: now.Add(-time.Hour).UnixNano()},
+		"live":              {Value: []byte("v"), Version: old},
+	}
+	for key, e := range entries {
+		if _, err := s.PutEntry(key, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reclaimed, purged, err := s.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != 1 || purged != 1 {
+		t.Fatalf("Sweep = %d reclaimed, %d purged; want 1, 1", reclaimed, purged)
+	}
+
+	if e, err := s.GetEntry("expired"); err != nil || !e.Deleted || e.Value != nil || e.Version != fresh {
+		t.Fatalf("expired = %+v, %v; want tombstone with the same version", e, err)
+	}
+	if _, err := s.GetEntry("old-tombstone"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("old-tombstone: err = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"just-expired", "new-tombstone", "expired-tombstone", "live"} {
+		if e, err := s.GetEntry(key); err != nil || e.Deleted != entries[key].Deleted {
+			t.Fatalf("%s = %+v, %v; want unchanged", key, e, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version - метка гибридных логических часов (HLC) и id ноды, сделавшей запись.
+// Версии упорядочены по (Wall, Logical, Node), поэтому любые две различные
+// записи одного ключа сравнимы, а при конфликте побеждает более новая.
+type Version struct {
+	Wall    int64
+	Logical uint32
+	Node    string
+}
+
+func (v Version) IsZero() bool {
+	return v.Wall == 0 && v.Logical == 0 && v.Node == ""
+}
+
+// Compare возвращает -1, 0 или 1, если v старше, равна или новее o
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.Wall != o.Wall:
+		return order(v.Wall < o.Wall)
+	case v.Logical != o.Logical:
+		return order(v.Logical < o.Logical)
+	case v.Node != o.Node:
+		return order(v.Node < o.Node)
+	}
+	return 0
+}
+
+func order(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// String кодирует версию в вид "<wall>.<logical>.<node>"
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%s", v.Wall, v.Logical, v.Node)
+}
+
+func ParseVersion(s string) (Version, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("bad version %q", s)
+	}
+	wall, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Version{}, fmt.Errorf("bad version %q: %w", s, err)
+	}
+	logical, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Version{}, fmt.Errorf("bad version %q: %w", s, err)
+	}
+	return Version{Wall: wall, Logical: uint32(logical), Node: parts[2]}, nil
+}
+
+// Clock - гибридные логические часы ноды
+type Clock struct {
+	mu      sync.Mutex
+	node    string
+	wall    int64
+	logical uint32
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{node: node}
+}
+
+// Now выдает новую версию, строго большую всех выданных и увиденных ранее
+func (c *Clock) Now() Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := time.Now().UnixNano()
+	if pt > c.wall {
+		c.wall = pt
+		c.logical = 0
+	} else {
+		c.logical++
+	}
+	return Version{Wall: c.wall, Logical: c.logical, Node: c.node}
+}
+
+// Observe сдвигает часы вперед по версии, полученной от другой ноды,
+// чтобы следующие записи этой ноды были новее нее
+func (c *Clock) Observe(v Version) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := time.Now().UnixNano()
+	switch {
+	case pt > c.wall && pt > v.Wall:
+		c.wall = pt
+		c.logical = 0
+	case v.Wall > c.wall:
+		c.wall = v.Wall
+		c.logical = v.Logical + 1
+	case v.Wall == c.wall:
+		if v.Logical > c.logical {
+			c.logical = v.Logical
+		}
+		c.logical++
+	default:
+		c.logical++
+	}
+}
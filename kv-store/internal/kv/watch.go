@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionBuffer - сколько событий подписка копит, пока читатель не успевает
+const subscriptionBuffer = 1024
+
+// Event - изменение ключа в локальном хранилище: запись значения или
+// удаление (Entry.Deleted), в том числе истечение TTL
+type Event struct {
+	Key   string
+	Entry Entry
+}
+
+// Subscription - поток изменений ключей с заданным префиксом.
+// Если читатель отстает больше чем на subscriptionBuffer событий, подписка
+// закрывается (C закрывается, Lagged() = true): пропущенные события не теряются
+// молча, а читатель узнает, что нужно переподписаться.
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	prefix string
+	store  *Store
+	lagged atomic.Bool
+	once   sync.Once
+}
+
+// Subscribe подписывается на изменения ключей с префиксом prefix
+func (s *Store) Subscribe(prefix string) *Subscription {
+	ch := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, prefix: prefix, store: s}
+
+	s.subsMu.Lock()
+	s.subs[sub] = struct{}{}
+	s.subsMu.Unlock()
+	return sub
+}
+
+// Close отменяет подписку
+func (sub *Subscription) Close() {
+	sub.store.subsMu.Lock()
+	defer sub.store.subsMu.Unlock()
+	sub.closeLocked()
+}
+
+// Lagged сообщает, что подписка закрыта из-за переполнения
+func (sub *Subscription) Lagged() bool {
+	return sub.lagged.Load()
+}
+
+func (sub *Subscription) closeLocked() {
+	sub.once.Do(func() {
+		delete(sub.store.subs, sub)
+		close(sub.ch)
+	})
+}
+
+// publish рассылает изменение подписчикам. Не блокируется: переполненная
+// подписка закрывается.
+func (s *Store) publish(key string, e Entry) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	for sub := range s.subs {
+		if !strings.HasPrefix(key, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.ch <- Event{Key: key, Entry: copyEntry(e)}:
+		default:
+			sub.lagged.Store(true)
+			sub.closeLocked()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package lsm
+
+import "hash/fnv"
+
+const bloomBitsPerKey = 10
+
+// bloomFilter - битовый массив; последний байт хранит число хеш-функций
+type bloomFilter []byte
+
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func buildBloom(hashes []uint64) bloomFilter {
+	bits := len(hashes) * bloomBitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	nBytes := (bits + 7) / 8
+	bits = nBytes * 8
+
+	// k = bitsPerKey * ln2 минимизирует вероятность ложного срабатывания
+	k := byte(bloomBitsPerKey * 69 / 100)
+	if k < 1 {
+		k = 1
+	}
+
+	filter := make(bloomFilter, nBytes+1)
+	filter[nBytes] = k
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := byte(0); i < k; i++ {
+			bit := (h1 + uint32(i)*h2) % uint32(bits)
+			filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// mayContain возвращает false, только если ключа в таблице точно нет
+func (f bloomFilter) mayContain(h uint64) bool {
+	if len(f) < 2 {
+		return true
+	}
+	nBytes := len(f) - 1
+	bits := uint32(nBytes * 8)
+	k := f[nBytes]
+
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := byte(0); i < k; i++ {
+		bit := (h1 + uint32(i)*h2) % bits
+		if f[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
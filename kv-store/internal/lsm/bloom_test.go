@@ -0,0 +1,36 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomHasNoFalseNegatives(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, keyHash(fmt.Sprintf("key-%d", i)))
+	}
+	f := buildBloom(hashes)
+	for i, h := range hashes {
+		if !f.mayContain(h) {
+			t.Fatalf("key-%d missing from filter", i)
+		}
+	}
+
+	// При 10 битах на ключ ложных срабатываний около 1%
+	falsePos := 0
+	for i := 0; i < 10000; i++ {
+		if f.mayContain(keyHash(fmt.Sprintf("other-%d", i))) {
+			falsePos++
+		}
+	}
+	if falsePos > 300 {
+		t.Fatalf("%d false positives out of 10000", falsePos)
+	}
+}
+
+func TestEmptyBloomMatchesEverything(t *testing.T) {
+	if !bloomFilter(nil).mayContain(keyHash("any")) {
+		t.Fatal("empty filter rejected a key")
+	}
+}
@@ -0,0 +1,200 @@
+package lsm
+
+import "log"
+
+const (
+	numLevels = 7
+
+	// l0CompactionTrigger - число таблиц в L0, после которого они сливаются в L1
+	l0CompactionTrigger = 4
+
+	// Размер L1; каждый следующий уровень в levelMultiplier раз больше
+	baseLevelSize   = 10 << 20
+	levelMultiplier = 10
+
+	targetFileSize = 2 << 20
+)
+
+func maxLevelSize(level int) float64 {
+	size := float64(baseLevelSize)
+	for i := 1; i < level; i++ {
+		size *= levelMultiplier
+	}
+	return size
+}
+
+// compaction - таблицы уровня level и пересекающиеся с ними таблицы level+1
+type compaction struct {
+	level  int
+	inputs [2][]*table
+}
+
+// pickCompaction выбирает уровень с наибольшим превышением лимита
+func (d *DB) pickCompaction() *compaction {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	best, bestScore := -1, 1.0
+	for lvl := 0; lvl < numLevels-1; lvl++ {
+		var score float64
+		if lvl == 0 {
+			score = float64(len(d.levels[0])) / l0CompactionTrigger
+		} else {
+			var size int64
+			for _, t := range d.levels[lvl] {
+				size += t.meta.Size
+			}
+			score = float64(size) / maxLevelSize(lvl)
+		}
+		if score >= bestScore {
+			best, bestScore = lvl, score
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	c := &compaction{level: best}
+	if best == 0 {
+		// Таблицы L0 пересекаются, поэтому сливаются все сразу
+		c.inputs[0] = append([]*table{}, d.levels[0]...)
+	} else {
+		// Таблицы уровня обходятся по кругу, начиная после последнего сжатого ключа
+		tables := d.levels[best]
+		pick := tables[0]
+		for _, t := range tables {
+			if t.meta.smallest() > d.compactPtr[best] {
+				pick = t
+				break
+			}
+		}
+		c.inputs[0] = []*table{pick}
+	}
+
+	lo, hi := keyRange(c.inputs[0])
+	for _, t := range d.levels[best+1] {
+		if t.meta.overlaps(lo, hi) {
+			c.inputs[1] = append(c.inputs[1], t)
+		}
+	}
+	return c
+}
+
+// compact выполняет одну компакцию. did = false, если делать нечего.
+func (d *DB) compact() (did bool, err error) {
+	c := d.pickCompaction()
+	if c == nil {
+		return false, nil
+	}
+
+	_, hi := keyRange(c.inputs[0])
+	d.compactPtr[c.level] = hi
+
+	levels := d.cloneLevels()
+	var outputs []*table
+
+	moved := c.level > 0 && len(c.inputs[1]) == 0
+	if moved {
+		// Следующий уровень не пересекается с таблицей: переносим ее без перезаписи
+		outputs = c.inputs[0]
+	} else {
+		var iters []iterator
+		for _, t := range c.inputs[0] {
+			iters = append(iters, t.iter(""))
+		}
+		iters = append(iters, newLevelIterator(c.inputs[1], ""))
+
+		outputs, err = d.writeTables(newMergingIterator(iters), func(key string) bool {
+			return d.isBaseLevel(levels, c.level+2, key)
+		}, targetFileSize)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	levels[c.level] = without(levels[c.level], c.inputs[0])
+	next := without(levels[c.level+1], c.inputs[1])
+	levels[c.level+1] = insertSorted(next, outputs)
+
+	if err := d.saveManifest(levels, d.flushedSeq); err != nil {
+		if !moved {
+			removeTables(outputs)
+		}
+		return false, err
+	}
+
+	d.mu.Lock()
+	d.levels = levels
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	// Get держит RLock на время запроса и после замены версии старые таблицы
+	// не увидит; Scan держит на них ссылки, и файлы удалятся после него
+	if !moved {
+		removeTables(c.inputs[0])
+		removeTables(c.inputs[1])
+	}
+
+	var inBytes, outBytes int64
+	for _, in := range c.inputs {
+		for _, t := range in {
+			inBytes += t.meta.Size
+		}
+	}
+	for _, t := range outputs {
+		outBytes += t.meta.Size
+	}
+	log.Printf("LSM compaction L%d -> L%d: %d+%d tables (%d bytes) -> %d tables (%d bytes)",
+		c.level, c.level+1, len(c.inputs[0]), len(c.inputs[1]), inBytes, len(outputs), outBytes)
+	return true, nil
+}
+
+// isBaseLevel проверяет, что на уровнях начиная с from нет таблиц с ключом key,
+// и значит tombstone можно не переносить дальше
+func (d *DB) isBaseLevel(levels [][]*table, from int, key string) bool {
+	for lvl := from; lvl < numLevels; lvl++ {
+		for _, t := range levels[lvl] {
+			if t.meta.overlaps(key, key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func keyRange(tables []*table) (lo, hi string) {
+	for i, t := range tables {
+		if i == 0 || t.meta.smallest() < lo {
+			lo = t.meta.smallest()
+		}
+		if i == 0 || t.meta.largest() > hi {
+			hi = t.meta.largest()
+		}
+	}
+	return lo, hi
+}
+
+func without(tables, remove []*table) []*table {
+	drop := make(map[*table]bool, len(remove))
+	for _, t := range remove {
+		drop[t] = true
+	}
+	out := make([]*table, 0, len(tables))
+	for _, t := range tables {
+		if !drop[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// insertSorted добавляет таблицы в уровень, сохраняя порядок по smallest
+func insertSorted(tables, add []*table) []*table {
+	out := append(tables, add...)
+	for i := len(tables); i < len(out); i++ {
+		for j := i; j > 0 && out[j].meta.smallest() < out[j-1].meta.smallest(); j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,632 @@
+// Package lsm - встроенное LSM-дерево: запись идет в журнал и в memtable,
+// заполненная memtable сбрасывается в SSTable на уровень L0, а фоновая
+// компакция сливает таблицы в уровни L1..L6 с непересекающимися диапазонами.
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kv-store/internal/wal"
+)
+
+const (
+	defaultMemtableSize = 4 << 20
+
+	// l0StopTrigger - при таком числе таблиц в L0 запись ждет компакцию
+	l0StopTrigger = 12
+
+	tableSuffix = ".sst"
+)
+
+var (
+	ErrNotFound = errors.New("lsm: not found")
+	ErrClosed   = errors.New("lsm: closed")
+)
+
+type Options struct {
+	Dir          string
+	Sync         wal.SyncPolicy
+	SyncInterval time.Duration
+
+	// MemtableSize - размер memtable в байтах, после которого она сбрасывается на диск
+	MemtableSize int
+}
+
+type DB struct {
+	dir  string
+	opts Options
+
+	// writeMu упорядочивает записи: порядок в журнале совпадает с порядком в memtable
+	writeMu sync.Mutex
+
+	mu     sync.RWMutex
+	cond   *sync.Cond
+	mem    *memtable
+	memSeq uint64
+	imm    *memtable // memtable, которая сейчас сбрасывается на диск
+	immSeq uint64
+	levels [][]*table
+	bgErr  error
+	closed bool
+
+	// Меняются только фоновой горутиной
+	nextFile   uint64
+	flushedSeq uint64
+	compactPtr [numLevels]string
+
+	wal  *wal.Log
+	work chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open открывает базу в opts.Dir и проигрывает журнал поверх сохраненных таблиц
+func Open(opts Options) (*DB, error) {
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = defaultMemtableSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m, err := loadManifest(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+
+	d := &DB{
+		dir:        opts.Dir,
+		opts:       opts,
+		mem:        newMemtable(),
+		levels:     make([][]*table, numLevels),
+		nextFile:   m.NextFile,
+		flushedSeq: m.FlushedSeq,
+		work:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	d.cond = sync.NewCond(&d.mu)
+
+	live := make(map[uint64]bool)
+	for lvl, metas := range m.Levels {
+		if lvl >= numLevels {
+			d.closeTables()
+			return nil, fmt.Errorf("manifest: level %d out of range", lvl)
+		}
+		for _, meta := range metas {
+			t, err := openTable(d.tablePath(meta.Num), meta)
+			if err != nil {
+				d.closeTables()
+				return nil, err
+			}
+			d.levels[lvl] = append(d.levels[lvl], t)
+			live[meta.Num] = true
+		}
+	}
+	d.removeOrphans(live)
+
+	journal, err := wal.Open(wal.Options{
+		Dir:          filepath.Join(opts.Dir, "wal"),
+		Sync:         opts.Sync,
+		SyncInterval: opts.SyncInterval,
+		StartSeq:     m.FlushedSeq,
+	}, d.replay)
+	if err != nil {
+		d.closeTables()
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	d.wal = journal
+	d.memSeq = journal.LastSeq()
+
+	d.wg.Add(1)
+	go d.background()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mem.size >= opts.MemtableSize {
+		if err := d.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	d.schedule()
+	return d, nil
+}
+
+// Get возвращает значение ключа или ErrNotFound
+func (d *DB) Get(key string) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+	for _, m := range []*memtable{d.mem, d.imm} {
+		if m == nil {
+			continue
+		}
+		if value, kind, ok := m.get(key); ok {
+			return found(value, kind)
+		}
+	}
+
+	hash := keyHash(key)
+	// L0 хранится от новых таблиц к старым, и их диапазоны пересекаются
+	for _, t := range d.levels[0] {
+		value, kind, ok, err := t.get(key, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return found(value, kind)
+		}
+	}
+	for _, tables := range d.levels[1:] {
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].meta.largest() >= key })
+		if i == len(tables) {
+			continue
+		}
+		value, kind, ok, err := tables[i].get(key, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return found(value, kind)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func found(value []byte, kind byte) ([]byte, error) {
+	if kind == kindDelete {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (d *DB) Put(key string, value []byte) error {
+	return d.write(key, kindPut, append([]byte{}, value...))
+}
+
+// Delete записывает tombstone; место освобождается при компакции
+func (d *DB) Delete(key string) error {
+	return d.write(key, kindDelete, nil)
+}
+
+func (d *DB) write(key string, kind byte, value []byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	if err := d.makeRoom(); err != nil {
+		return err
+	}
+	seq, err := d.wal.Append(encodeOp(key, kind, value))
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.mem.put(key, kind, value)
+	d.memSeq = seq
+	d.mu.Unlock()
+	return nil
+}
+
+// Scan обходит живые ключи начиная со start в порядке возрастания,
+// пока fn возвращает true. Обход идет по версии, закрепленной при вызове,
+// и не держит DB.mu: запись, flush и компакция во время обхода не ждут.
+// Записи, сделанные после начала обхода, могут в него не попасть.
+func (d *DB) Scan(start string, fn func(key string, value []byte) bool) error {
+	v, err := d.pin()
+	if err != nil {
+		return err
+	}
+	defer v.release()
+
+	iters := []iterator{v.mem.iter(start)}
+	if v.imm != nil {
+		iters = append(iters, v.imm.iter(start))
+	}
+	for _, t := range v.levels[0] {
+		iters = append(iters, t.iter(start))
+	}
+	for _, tables := range v.levels[1:] {
+		iters = append(iters, newLevelIterator(tables, start))
+	}
+
+	it := newMergingIterator(iters)
+	for ; it.valid(); it.next() {
+		if it.kind() == kindDelete {
+			continue
+		}
+		if !fn(it.key(), it.value()) {
+			return nil
+		}
+	}
+	return it.err()
+}
+
+// version - memtable и таблицы, которые видел Scan в момент вызова
+type version struct {
+	mem    *memtable
+	imm    *memtable
+	levels [][]*table
+}
+
+// pin закрепляет текущую версию: ее таблицы не закроются и не удалятся,
+// пока не вызван release. Списки уровней не меняются на месте (flush и
+// компакция собирают новые), поэтому их достаточно запомнить.
+func (d *DB) pin() (*version, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+	v := &version{mem: d.mem, imm: d.imm, levels: d.levels}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.ref()
+		}
+	}
+	return v, nil
+}
+
+func (v *version) release() {
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.unref()
+		}
+	}
+}
+
+// Flush сбрасывает текущую memtable в SSTable и ждет окончания записи
+func (d *DB) Flush() error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.waitImmLocked(); err != nil {
+		return err
+	}
+	if d.mem.count == 0 {
+		return nil
+	}
+	if err := d.rotateLocked(); err != nil {
+		return err
+	}
+	return d.waitImmLocked()
+}
+
+// Close дожидается фоновой работы и закрывает журнал и таблицы.
+// Несброшенная memtable восстановится из журнала при следующем открытии.
+func (d *DB) Close() error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	close(d.done)
+	d.wg.Wait()
+
+	err := d.wal.Close()
+	d.closeTables()
+	return err
+}
+
+// makeRoom переносит заполненную memtable в imm. Если предыдущая еще
+// не сброшена или в L0 слишком много таблиц, запись ждет фоновую работу.
+func (d *DB) makeRoom() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		switch {
+		case d.closed:
+			return ErrClosed
+		case d.bgErr != nil:
+			return d.bgErr
+		case d.mem.size < d.opts.MemtableSize:
+			return nil
+		case d.imm != nil || len(d.levels[0]) >= l0StopTrigger:
+			d.cond.Wait()
+		default:
+			return d.rotateLocked()
+		}
+	}
+}
+
+// rotateLocked делает текущую memtable неизменяемой и начинает новый сегмент
+// журнала, чтобы после сброса старые сегменты можно было удалить
+func (d *DB) rotateLocked() error {
+	if err := d.wal.Roll(); err != nil {
+		return err
+	}
+	d.imm = d.mem
+	d.immSeq = d.memSeq
+	d.mem = newMemtable()
+	d.schedule()
+	return nil
+}
+
+func (d *DB) waitImmLocked() error {
+	for d.imm != nil && d.bgErr == nil && !d.closed {
+		d.cond.Wait()
+	}
+	if d.closed {
+		return ErrClosed
+	}
+	return d.bgErr
+}
+
+func (d *DB) schedule() {
+	select {
+	case d.work <- struct{}{}:
+	default:
+	}
+}
+
+// background сбрасывает imm на диск и выполняет компакции, пока есть работа
+func (d *DB) background() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-d.work:
+		}
+
+		for {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+
+			d.mu.RLock()
+			imm := d.imm
+			d.mu.RUnlock()
+
+			var (
+				did bool
+				err error
+			)
+			if imm != nil {
+				did, err = true, d.flushImm(imm)
+			} else {
+				did, err = d.compact()
+			}
+			if err != nil {
+				log.Printf("ERR: LSM background work failed: %v", err)
+				d.mu.Lock()
+				d.bgErr = err
+				d.cond.Broadcast()
+				d.mu.Unlock()
+				return
+			}
+			if !did {
+				break
+			}
+		}
+	}
+}
+
+// flushImm пишет imm в новую таблицу уровня L0
+func (d *DB) flushImm(imm *memtable) error {
+	d.mu.RLock()
+	seq := d.immSeq
+	d.mu.RUnlock()
+
+	var added []*table
+	if imm.count > 0 {
+		t, err := d.writeTables(imm.iter(""), func(string) bool { return false }, 0)
+		if err != nil {
+			return err
+		}
+		added = t
+	}
+
+	levels := d.cloneLevels()
+	levels[0] = append(added, levels[0]...)
+	if err := d.saveManifest(levels, seq); err != nil {
+		removeTables(added)
+		return err
+	}
+
+	d.mu.Lock()
+	d.levels = levels
+	d.imm = nil
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	// Записи imm уже в таблице: сегменты журнала до нее больше не нужны
+	if _, err := d.wal.TruncateBefore(seq); err != nil {
+		log.Printf("ERR: Failed to truncate LSM WAL: %v", err)
+	}
+	return nil
+}
+
+// writeTables пишет записи итератора в таблицы размером до targetFileSize.
+// Tombstone пропускается, если drop(key) говорит, что под ним нет старых версий.
+func (d *DB) writeTables(it iterator, drop func(key string) bool, maxSize uint64) ([]*table, error) {
+	var (
+		tables []*table
+		w      *tableWriter
+		num    uint64
+	)
+	fail := func(err error) ([]*table, error) {
+		if w != nil {
+			w.abort()
+		}
+		removeTables(tables)
+		return nil, err
+	}
+	finish := func() error {
+		meta, err := w.finish()
+		if err != nil {
+			return err
+		}
+		w = nil
+		meta.Num = num
+		t, err := openTable(d.tablePath(num), meta)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, t)
+		return nil
+	}
+
+	for ; it.valid(); it.next() {
+		if it.kind() == kindDelete && drop(it.key()) {
+			continue
+		}
+		if w == nil {
+			num = d.nextFile
+			d.nextFile++
+			var err error
+			if w, err = newTableWriter(d.tablePath(num)); err != nil {
+				return fail(err)
+			}
+		}
+		if err := w.add(it.key(), it.kind(), it.value()); err != nil {
+			return fail(err)
+		}
+		if maxSize > 0 && w.estimatedSize() >= maxSize {
+			if err := finish(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := it.err(); err != nil {
+		return fail(err)
+	}
+	if w != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+	return tables, nil
+}
+
+// cloneLevels копирует списки таблиц, чтобы собрать новую версию
+// без изменения той, которую сейчас читают
+func (d *DB) cloneLevels() [][]*table {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	levels := make([][]*table, numLevels)
+	for i, tables := range d.levels {
+		levels[i] = append([]*table{}, tables...)
+	}
+	return levels
+}
+
+func (d *DB) saveManifest(levels [][]*table, flushedSeq uint64) error {
+	m := manifest{
+		NextFile:   d.nextFile,
+		FlushedSeq: flushedSeq,
+		Levels:     make([][]tableMeta, numLevels),
+	}
+	for i, tables := range levels {
+		m.Levels[i] = []tableMeta{}
+		for _, t := range tables {
+			m.Levels[i] = append(m.Levels[i], t.meta)
+		}
+	}
+	if err := saveManifest(d.dir, m); err != nil {
+		return err
+	}
+	d.flushedSeq = flushedSeq
+	return nil
+}
+
+// replay применяет запись журнала к memtable при открытии
+func (d *DB) replay(_ uint64, payload []byte) error {
+	key, kind, value, err := decodeOp(payload)
+	if err != nil {
+		return err
+	}
+	d.mem.put(key, kind, value)
+	return nil
+}
+
+func (d *DB) tablePath(num uint64) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%06d%s", num, tableSuffix))
+}
+
+// removeOrphans удаляет таблицы, не попавшие в манифест (падение во время flush или компакции)
+func (d *DB) removeOrphans(live map[uint64]bool) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, tableSuffix) {
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, tableSuffix), 10, 64)
+		if err != nil || live[num] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, name)); err == nil {
+			log.Printf("Removed orphan LSM table %s", name)
+		}
+	}
+}
+
+// closeTables отпускает ссылки версии на таблицы; таблицы, которые
+// еще обходит Scan, закроются по его окончании
+func (d *DB) closeTables() {
+	for _, tables := range d.levels {
+		for _, t := range tables {
+			t.unref()
+		}
+	}
+}
+
+// removeTables исключает таблицы из базы: файл удаляется, как только
+// его отпустит последний Scan
+func removeTables(tables []*table) {
+	for _, t := range tables {
+		t.obsolete.Store(true)
+		t.unref()
+	}
+}
+
+// Запись журнала: kind | uvarint len(key) key | value
+func encodeOp(key string, kind byte, value []byte) []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(value))
+	buf = append(buf, kind)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+func decodeOp(buf []byte) (string, byte, []byte, error) {
+	if len(buf) < 1 {
+		return "", 0, nil, wal.ErrCorrupt
+	}
+	kind := buf[0]
+	n, key, ok := readBytes(buf[1:])
+	if !ok || kind > kindPut {
+		return "", 0, nil, wal.ErrCorrupt
+	}
+	return string(key), kind, append([]byte{}, buf[1+n:]...), nil
+}
@@ -0,0 +1,166 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	d, err := Open(Options{Dir: dir, MemtableSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// flushN записывает n таблиц L0 с ключами prefix-000..., по одной на каждый сброс
+func flushN(t *testing.T, d *DB, n int, prefix string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		for j := 0; j < 10; j++ {
+			key := fmt.Sprintf("%s-%03d", prefix, j)
+			if err := d.Put(key, []byte(fmt.Sprintf("v%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitCompacted ждет, пока фоновая компакция разберет L0
+func waitCompacted(t *testing.T, d *DB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.RLock()
+		l0, err := len(d.levels[0]), d.bgErr
+		d.mu.RUnlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l0 < l0CompactionTrigger {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compaction did not run, L0 has %d tables", l0)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func tableFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+tableSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCompactionMergesL0(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	defer d.Close()
+
+	flushN(t, d, l0CompactionTrigger, "k")
+	if err := d.Delete("k-005"); err != nil {
+		t.Fatal(err)
+	}
+	waitCompacted(t, d)
+
+	d.mu.RLock()
+	l1 := len(d.levels[1])
+	d.mu.RUnlock()
+	if l1 == 0 {
+		t.Fatal("no tables in L1 after compaction")
+	}
+
+	// Последний сброс перекрывает предыдущие версии ключей
+	v, err := d.Get("k-003")
+	if err != nil || string(v) != fmt.Sprintf("v%d", l0CompactionTrigger-1) {
+		t.Fatalf("Get k-003 = %q, %v", v, err)
+	}
+	if _, err := d.Get("k-005"); err != ErrNotFound {
+		t.Fatalf("deleted key: %v", err)
+	}
+
+	// Входные таблицы удалены с диска
+	d.mu.RLock()
+	live := 0
+	for _, tables := range d.levels {
+		live += len(tables)
+	}
+	d.mu.RUnlock()
+	if files := tableFiles(t, dir); len(files) != live {
+		t.Fatalf("%d table files on disk, %d in levels", len(files), live)
+	}
+}
+
+func TestReopenKeepsFlushedAndLoggedData(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	flushN(t, d, 2, "a")
+	// Эта запись есть только в журнале
+	if err := d.Put("b", []byte("wal")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestDB(t, dir)
+	defer d.Close()
+	for key, want := range map[string]string{"a-000": "v1", "b": "wal"} {
+		v, err := d.Get(key)
+		if err != nil || string(v) != want {
+			t.Fatalf("Get %s = %q, %v; want %q", key, v, err, want)
+		}
+	}
+}
+
+func TestScanPinsVersion(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDB(t, dir)
+	defer d.Close()
+
+	flushN(t, d, 1, "k")
+	before := tableFiles(t, dir)
+
+	var keys []string
+	err := d.Scan("", func(key string, value []byte) bool {
+		if len(keys) == 0 {
+			// Обход не держит DB.mu: запись, сброс и компакция идут во время него
+			flushN(t, d, l0CompactionTrigger, "k")
+			waitCompacted(t, d)
+			for _, f := range before {
+				if _, err := os.Stat(f); err != nil {
+					t.Errorf("table of the pinned version removed during scan: %v", err)
+				}
+			}
+		}
+		keys = append(keys, key)
+		if string(value) != "v0" {
+			t.Errorf("%s = %q, want the value of the pinned version", key, value)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 10 {
+		t.Fatalf("scanned %d keys, want 10", len(keys))
+	}
+
+	// После обхода выбывшие таблицы удаляются
+	for _, f := range before {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("compacted table %s still on disk: %v", f, err)
+		}
+	}
+}
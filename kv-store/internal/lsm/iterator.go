@@ -0,0 +1,108 @@
+package lsm
+
+// iterator обходит записи в порядке возрастания ключей
+type iterator interface {
+	valid() bool
+	key() string
+	kind() byte
+	value() []byte
+	next()
+	err() error
+}
+
+// mergingIterator объединяет несколько источников. Источники упорядочены
+// от новых к старым: при совпадении ключей берется запись из более нового,
+// а остальные пропускаются.
+type mergingIterator struct {
+	iters []iterator
+	cur   int
+	e     error
+}
+
+func newMergingIterator(iters []iterator) *mergingIterator {
+	m := &mergingIterator{iters: iters}
+	m.pick()
+	return m
+}
+
+func (m *mergingIterator) pick() {
+	m.cur = -1
+	for i, it := range m.iters {
+		if err := it.err(); err != nil {
+			m.e = err
+			return
+		}
+		if !it.valid() {
+			continue
+		}
+		if m.cur < 0 || it.key() < m.iters[m.cur].key() {
+			m.cur = i
+		}
+	}
+}
+
+func (m *mergingIterator) valid() bool   { return m.e == nil && m.cur >= 0 }
+func (m *mergingIterator) key() string   { return m.iters[m.cur].key() }
+func (m *mergingIterator) kind() byte    { return m.iters[m.cur].kind() }
+func (m *mergingIterator) value() []byte { return m.iters[m.cur].value() }
+func (m *mergingIterator) err() error    { return m.e }
+
+func (m *mergingIterator) next() {
+	key := m.key()
+	for _, it := range m.iters {
+		for it.valid() && it.key() == key {
+			it.next()
+		}
+	}
+	m.pick()
+}
+
+// levelIterator последовательно обходит непересекающиеся таблицы одного уровня
+type levelIterator struct {
+	tables []*table
+	idx    int
+	cur    iterator
+	e      error
+}
+
+func newLevelIterator(tables []*table, start string) *levelIterator {
+	it := &levelIterator{tables: tables}
+	for it.idx < len(tables) && tables[it.idx].meta.largest() < start {
+		it.idx++
+	}
+	it.open(start)
+	return it
+}
+
+// open открывает итератор по текущей таблице, пропуская пустые
+func (it *levelIterator) open(start string) {
+	for ; it.idx < len(it.tables); it.idx++ {
+		it.cur = it.tables[it.idx].iter(start)
+		if err := it.cur.err(); err != nil {
+			it.e = err
+			return
+		}
+		if it.cur.valid() {
+			return
+		}
+	}
+	it.cur = nil
+}
+
+func (it *levelIterator) valid() bool   { return it.e == nil && it.cur != nil && it.cur.valid() }
+func (it *levelIterator) key() string   { return it.cur.key() }
+func (it *levelIterator) kind() byte    { return it.cur.kind() }
+func (it *levelIterator) value() []byte { return it.cur.value() }
+func (it *levelIterator) err() error    { return it.e }
+
+func (it *levelIterator) next() {
+	it.cur.next()
+	if err := it.cur.err(); err != nil {
+		it.e = err
+		return
+	}
+	if !it.cur.valid() {
+		it.idx++
+		it.open("")
+	}
+}
@@ -0,0 +1,143 @@
+package lsm
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const maxHeight = 12
+
+// Виды записей в memtable и SSTable
+const (
+	kindDelete byte = 0
+	kindPut    byte = 1
+)
+
+type skipNode struct {
+	key   string
+	value []byte
+	kind  byte
+	next  []*skipNode
+}
+
+// memtable - отсортированный skiplist с последними изменениями.
+// Запись идет под DB.mu; собственный mu нужен итератору Scan, который
+// обходит memtable без DB.mu, пока в нее продолжают писать.
+type memtable struct {
+	mu     sync.RWMutex
+	head   *skipNode
+	height int
+	size   int
+	count  int
+	rnd    *rand.Rand
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &skipNode{next: make([]*skipNode, maxHeight)},
+		height: 1,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (m *memtable) randomHeight() int {
+	h := 1
+	for h < maxHeight && m.rnd.Intn(4) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGE возвращает первый узел с ключом >= key. Если prev != nil,
+// в него записываются предшественники на каждом уровне.
+func (m *memtable) findGE(key string, prev []*skipNode) *skipNode {
+	x := m.head
+	for lvl := m.height - 1; lvl >= 0; lvl-- {
+		for next := x.next[lvl]; next != nil && next.key < key; next = x.next[lvl] {
+			x = next
+		}
+		if prev != nil {
+			prev[lvl] = x
+		}
+	}
+	return x.next[0]
+}
+
+func (m *memtable) put(key string, kind byte, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := make([]*skipNode, maxHeight)
+	n := m.findGE(key, prev)
+	if n != nil && n.key == key {
+		m.size += len(value) - len(n.value)
+		n.value = value
+		n.kind = kind
+		return
+	}
+
+	h := m.randomHeight()
+	if h > m.height {
+		for i := m.height; i < h; i++ {
+			prev[i] = m.head
+		}
+		m.height = h
+	}
+
+	n = &skipNode{key: key, value: value, kind: kind, next: make([]*skipNode, h)}
+	for i := 0; i < h; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	m.size += len(key) + len(value) + 8*h
+	m.count++
+}
+
+func (m *memtable) get(key string) ([]byte, byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := m.findGE(key, nil)
+	if n == nil || n.key != key {
+		return nil, 0, false
+	}
+	return n.value, n.kind, true
+}
+
+func (m *memtable) iter(start string) iterator {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	it := &memIterator{m: m}
+	it.set(m.findGE(start, nil))
+	return it
+}
+
+// memIterator копирует запись текущего узла под m.mu: значение узла
+// может смениться, пока итератор на нем стоит
+type memIterator struct {
+	m    *memtable
+	node *skipNode
+	cur  blockEntry
+}
+
+// set переходит на узел n. Вызывается под m.mu.
+func (it *memIterator) set(n *skipNode) {
+	it.node = n
+	if n != nil {
+		it.cur = blockEntry{key: n.key, kind: n.kind, value: n.value}
+	}
+}
+
+func (it *memIterator) valid() bool   { return it.node != nil }
+func (it *memIterator) key() string   { return it.cur.key }
+func (it *memIterator) kind() byte    { return it.cur.kind }
+func (it *memIterator) value() []byte { return it.cur.value }
+func (it *memIterator) err() error    { return nil }
+
+func (it *memIterator) next() {
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	it.set(it.node.next[0])
+}
@@ -0,0 +1,401 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// Формат SSTable:
+//
+//	[блок данных]...[bloom-фильтр][индекс][footer]
+//
+// Блок данных - записи kind | uvarint len(key) key | uvarint len(value) value
+// и crc32c в конце. Индекс для каждого блока хранит его последний ключ,
+// смещение и размер. Footer - смещения и размеры фильтра и индекса и magic.
+const (
+	blockSize  = 4 << 10
+	footerSize = 40
+	tableMagic = 0x4b56_4c53_4d54_3031 // "KVLSMT01"
+)
+
+var (
+	ErrCorrupt = errors.New("lsm: corrupt table")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// tableMeta описывает SSTable в манифесте
+type tableMeta struct {
+	Num      uint64 `json:"num"`
+	Size     int64  `json:"size"`
+	Smallest []byte `json:"smallest"`
+	Largest  []byte `json:"largest"`
+}
+
+func (m tableMeta) smallest() string { return string(m.Smallest) }
+func (m tableMeta) largest() string  { return string(m.Largest) }
+
+// overlaps проверяет, пересекается ли таблица с диапазоном [lo, hi]
+func (m tableMeta) overlaps(lo, hi string) bool {
+	return m.smallest() <= hi && m.largest() >= lo
+}
+
+type indexEntry struct {
+	lastKey string
+	offset  uint64
+	size    uint64
+}
+
+// tableWriter пишет отсортированные записи в новый файл SSTable
+type tableWriter struct {
+	f    *os.File
+	path string
+
+	offset  uint64
+	block   []byte
+	lastKey string
+	first   string
+	count   int
+	index   []indexEntry
+	hashes  []uint64
+}
+
+func newTableWriter(path string) (*tableWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{f: f, path: path}, nil
+}
+
+// add добавляет запись; ключи должны идти строго по возрастанию
+func (w *tableWriter) add(key string, kind byte, value []byte) error {
+	if w.count == 0 {
+		w.first = key
+	}
+	w.block = append(w.block, kind)
+	w.block = binary.AppendUvarint(w.block, uint64(len(key)))
+	w.block = append(w.block, key...)
+	w.block = binary.AppendUvarint(w.block, uint64(len(value)))
+	w.block = append(w.block, value...)
+	w.lastKey = key
+	w.hashes = append(w.hashes, keyHash(key))
+	w.count++
+
+	if len(w.block) >= blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	size, err := w.writeChecked(w.block)
+	if err != nil {
+		return err
+	}
+	w.index = append(w.index, indexEntry{lastKey: w.lastKey, offset: w.offset - size, size: size})
+	w.block = w.block[:0]
+	return nil
+}
+
+// writeChecked пишет данные с crc32c в конце и возвращает общий размер
+func (w *tableWriter) writeChecked(data []byte) (uint64, error) {
+	buf := binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+	if _, err := w.f.Write(buf); err != nil {
+		return 0, err
+	}
+	w.offset += uint64(len(buf))
+	return uint64(len(buf)), nil
+}
+
+// estimatedSize - примерный размер файла на текущий момент
+func (w *tableWriter) estimatedSize() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+// finish дописывает фильтр, индекс и footer и сбрасывает файл на диск
+func (w *tableWriter) finish() (tableMeta, error) {
+	if err := w.flushBlock(); err != nil {
+		return tableMeta{}, err
+	}
+
+	bloomOff := w.offset
+	bloomSize, err := w.writeChecked(buildBloom(w.hashes))
+	if err != nil {
+		return tableMeta{}, err
+	}
+
+	var idx []byte
+	for _, e := range w.index {
+		idx = binary.AppendUvarint(idx, uint64(len(e.lastKey)))
+		idx = append(idx, e.lastKey...)
+		idx = binary.AppendUvarint(idx, e.offset)
+		idx = binary.AppendUvarint(idx, e.size)
+	}
+	indexOff := w.offset
+	indexSize, err := w.writeChecked(idx)
+	if err != nil {
+		return tableMeta{}, err
+	}
+
+	footer := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(footer[0:], bloomOff)
+	binary.LittleEndian.PutUint64(footer[8:], bloomSize)
+	binary.LittleEndian.PutUint64(footer[16:], indexOff)
+	binary.LittleEndian.PutUint64(footer[24:], indexSize)
+	binary.LittleEndian.PutUint64(footer[32:], tableMagic)
+	if _, err := w.f.Write(footer); err != nil {
+		return tableMeta{}, err
+	}
+	w.offset += footerSize
+
+	if err := w.f.Sync(); err != nil {
+		return tableMeta{}, err
+	}
+	if err := w.f.Close(); err != nil {
+		return tableMeta{}, err
+	}
+	return tableMeta{
+		Size:     int64(w.offset),
+		Smallest: []byte(w.first),
+		Largest:  []byte(w.lastKey),
+	}, nil
+}
+
+// abort закрывает и удаляет недописанный файл
+func (w *tableWriter) abort() {
+	w.f.Close()
+	os.Remove(w.path)
+}
+
+// table - открытая SSTable. Индекс и фильтр держатся в памяти,
+// блоки данных читаются с диска по требованию.
+type table struct {
+	meta  tableMeta
+	f     *os.File
+	index []indexEntry
+	bloom bloomFilter
+
+	// refs - одна ссылка от текущей версии DB и по одной от каждого Scan,
+	// который обходит таблицу. Файл закрывается, когда ссылок не осталось,
+	// и удаляется, если таблица уже выбыла из версии (obsolete).
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+func openTable(path string, meta tableMeta) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{meta: meta, f: f}
+	t.refs.Store(1)
+	if err := t.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *table) load() error {
+	st, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() < footerSize {
+		return ErrCorrupt
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := t.f.ReadAt(footer, st.Size()-footerSize); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(footer[32:]) != tableMagic {
+		return ErrCorrupt
+	}
+
+	bloom, err := t.readChecked(binary.LittleEndian.Uint64(footer[0:]), binary.LittleEndian.Uint64(footer[8:]))
+	if err != nil {
+		return err
+	}
+	t.bloom = bloom
+
+	idx, err := t.readChecked(binary.LittleEndian.Uint64(footer[16:]), binary.LittleEndian.Uint64(footer[24:]))
+	if err != nil {
+		return err
+	}
+	for len(idx) > 0 {
+		var e indexEntry
+		n, key, ok := readBytes(idx)
+		if !ok {
+			return ErrCorrupt
+		}
+		idx = idx[n:]
+		e.lastKey = string(key)
+
+		var k int
+		if e.offset, k = binary.Uvarint(idx); k <= 0 {
+			return ErrCorrupt
+		}
+		idx = idx[k:]
+		if e.size, k = binary.Uvarint(idx); k <= 0 {
+			return ErrCorrupt
+		}
+		idx = idx[k:]
+		t.index = append(t.index, e)
+	}
+	return nil
+}
+
+// readChecked читает участок файла и проверяет crc32c в его конце
+func (t *table) readChecked(offset, size uint64) ([]byte, error) {
+	if size < 4 || size > uint64(t.meta.Size) {
+		return nil, ErrCorrupt
+	}
+	buf := make([]byte, size)
+	if _, err := t.f.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	data := buf[:size-4]
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(buf[size-4:]) {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+type blockEntry struct {
+	key   string
+	kind  byte
+	value []byte
+}
+
+func (t *table) readBlock(i int) ([]blockEntry, error) {
+	data, err := t.readChecked(t.index[i].offset, t.index[i].size)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []blockEntry
+	for len(data) > 0 {
+		var e blockEntry
+		e.kind = data[0]
+		data = data[1:]
+
+		n, key, ok := readBytes(data)
+		if !ok {
+			return nil, ErrCorrupt
+		}
+		data = data[n:]
+		e.key = string(key)
+
+		n, value, ok := readBytes(data)
+		if !ok {
+			return nil, ErrCorrupt
+		}
+		data = data[n:]
+		e.value = value
+
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// findBlock возвращает номер первого блока, который может содержать ключи >= key
+func (t *table) findBlock(key string) int {
+	return sort.Search(len(t.index), func(i int) bool { return t.index[i].lastKey >= key })
+}
+
+// get ищет ключ в таблице; found = false, если ключа в ней нет
+func (t *table) get(key string, hash uint64) (value []byte, kind byte, found bool, err error) {
+	if key < t.meta.smallest() || key > t.meta.largest() || !t.bloom.mayContain(hash) {
+		return nil, 0, false, nil
+	}
+	i := t.findBlock(key)
+	if i >= len(t.index) {
+		return nil, 0, false, nil
+	}
+	entries, err := t.readBlock(i)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	j := sort.Search(len(entries), func(j int) bool { return entries[j].key >= key })
+	if j < len(entries) && entries[j].key == key {
+		return entries[j].value, entries[j].kind, true, nil
+	}
+	return nil, 0, false, nil
+}
+
+func (t *table) iter(start string) iterator {
+	it := &tableIterator{t: t, block: t.findBlock(start)}
+	it.load()
+	for it.valid() && it.key() < start {
+		it.next()
+	}
+	return it
+}
+
+func (t *table) ref() {
+	t.refs.Add(1)
+}
+
+// unref отпускает ссылку; последняя закрывает файл
+func (t *table) unref() {
+	if t.refs.Add(-1) > 0 {
+		return
+	}
+	t.f.Close()
+	if t.obsolete.Load() {
+		os.Remove(t.f.Name())
+	}
+}
+
+type tableIterator struct {
+	t       *table
+	block   int
+	entries []blockEntry
+	pos     int
+	e       error
+}
+
+// load читает текущий блок, пропуская пустые
+func (it *tableIterator) load() {
+	for ; it.block < len(it.t.index); it.block++ {
+		it.entries, it.e = it.t.readBlock(it.block)
+		it.pos = 0
+		if it.e != nil || len(it.entries) > 0 {
+			return
+		}
+	}
+	it.entries = nil
+}
+
+func (it *tableIterator) valid() bool   { return it.e == nil && it.pos < len(it.entries) }
+func (it *tableIterator) key() string   { return it.entries[it.pos].key }
+func (it *tableIterator) kind() byte    { return it.entries[it.pos].kind }
+func (it *tableIterator) value() []byte { return it.entries[it.pos].value }
+func (it *tableIterator) err() error    { return it.e }
+
+func (it *tableIterator) next() {
+	it.pos++
+	if it.pos >= len(it.entries) {
+		it.block++
+		it.load()
+	}
+}
+
+// readBytes разбирает uvarint-длину и следующие за ней байты
+func readBytes(buf []byte) (int, []byte, bool) {
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < l {
+		return 0, nil, false
+	}
+	return n + int(l), buf[n : n+int(l)], true
+}
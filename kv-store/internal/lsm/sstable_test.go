@@ -0,0 +1,111 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTable пишет n ключей key-00000... в таблицу; каждый десятый - tombstone
+func writeTestTable(t *testing.T, n int) *table {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "000001"+tableSuffix)
+	w, err := newTableWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		kind, value := kindPut, []byte(fmt.Sprintf("value-%d", i))
+		if i%10 == 0 {
+			kind, value = kindDelete, nil
+		}
+		if err := w.add(fmt.Sprintf("key-%05d", i), kind, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta, err := w.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := openTable(path, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tbl.unref)
+	return tbl
+}
+
+func TestTableGet(t *testing.T) {
+	tbl := writeTestTable(t, 2000)
+	if len(tbl.index) < 2 {
+		t.Fatalf("expected several blocks, got %d", len(tbl.index))
+	}
+	if tbl.meta.smallest() != "key-00000" || tbl.meta.largest() != "key-01999" {
+		t.Fatalf("range [%s, %s]", tbl.meta.smallest(), tbl.meta.largest())
+	}
+
+	for _, i := range []int{1, 999, 1001, 1999} {
+		key := fmt.Sprintf("key-%05d", i)
+		value, kind, ok, err := tbl.get(key, keyHash(key))
+		if err != nil || !ok || kind != kindPut || string(value) != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("get %s = %q, %d, %v, %v", key, value, kind, ok, err)
+		}
+	}
+	// Tombstone находится и отличается от отсутствующего ключа
+	if _, kind, ok, err := tbl.get("key-00010", keyHash("key-00010")); err != nil || !ok || kind != kindDelete {
+		t.Fatalf("tombstone: kind %d, found %v, %v", kind, ok, err)
+	}
+	for _, key := range []string{"a", "key-000005", "key-1", "z"} {
+		if _, _, ok, err := tbl.get(key, keyHash(key)); ok || err != nil {
+			t.Fatalf("get %s: found %v, %v", key, ok, err)
+		}
+	}
+}
+
+func TestTableIter(t *testing.T) {
+	tbl := writeTestTable(t, 2000)
+
+	it := tbl.iter("key-00995")
+	var keys []string
+	for ; it.valid() && len(keys) < 10; it.next() {
+		keys = append(keys, it.key())
+	}
+	if err := it.err(); err != nil {
+		t.Fatal(err)
+	}
+	// Обход переходит через границу блока и не пропускает tombstone
+	for i, key := range keys {
+		if want := fmt.Sprintf("key-%05d", 995+i); key != want {
+			t.Fatalf("key %d = %s, want %s", i, key, want)
+		}
+	}
+
+	n := 0
+	for it := tbl.iter(""); it.valid(); it.next() {
+		n++
+	}
+	if n != 2000 {
+		t.Fatalf("full iteration returned %d entries", n)
+	}
+}
+
+func TestTableDetectsCorruptBlock(t *testing.T) {
+	tbl := writeTestTable(t, 2000)
+	path := tbl.f.Name()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "key-00001"
+	if _, _, _, err := tbl.get(key, keyHash(key)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("get from corrupt block: %v", err)
+	}
+}
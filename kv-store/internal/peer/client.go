@@ -0,0 +1,148 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"kv-store/internal/kv"
+)
+
+const (
+	// VersionHeader передает версию значения (см. kv.Version)
+	VersionHeader = "X-KV-Version"
+
+	// TombstoneHeader помечает, что версия относится к удалению ключа
+	TombstoneHeader = "X-KV-Tombstone"
+
+	// ExpiresHeader передает момент истечения TTL в наносекундах Unix
+	ExpiresHeader = "X-KV-Expires"
+)
+
+// StatusError - нода ответила на запрос, но с ошибкой (в отличие от
+// недоступной ноды, повтор того же запроса позже вряд ли поможет)
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d", e.Code)
+}
+
+// Client выполняет внутренние запросы между kv-нодами (репликация и т.п.)
+type Client struct {
+	http *http.Client
+	// stream - для долгоживущих ответов (/internal/watch), без общего таймаута
+	stream *http.Client
+}
+
+func NewClient(timeout time.Duration) *Client {
+	return &Client{
+		http:   &http.Client{Timeout: timeout},
+		stream: &http.Client{},
+	}
+}
+
+// Get читает запись из локального хранилища ноды addr, включая tombstone.
+// Если ключа на ноде нет, возвращается kv.ErrNotFound.
+func (c *Client) Get(ctx context.Context, addr, key string) (kv.Entry, error) {
+	resp, err := c.send(ctx, http.MethodGet, addr, "/internal/replica/get", key, kv.Entry{})
+	if err != nil {
+		return kv.Entry{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return kv.Entry{}, kv.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return kv.Entry{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	e, err := ParseEntryHeaders(resp.Header)
+	if err != nil {
+		return kv.Entry{}, err
+	}
+	if e.Value, err = io.ReadAll(resp.Body); err != nil {
+		return kv.Entry{}, err
+	}
+	return e, nil
+}
+
+// Put передает запись (значение или tombstone) ноде addr.
+// Нода применяет ее, только если версия новее локальной.
+func (c *Client) Put(ctx context.Context, addr, key string, e kv.Entry) error {
+	if e.Deleted {
+		return c.do(ctx, http.MethodDelete, addr, "/internal/replica/delete", key, e)
+	}
+	return c.do(ctx, http.MethodPut, addr, "/internal/replica/put", key, e)
+}
+
+// SetEntryHeaders записывает версию, признак удаления и срок жизни в заголовки
+func SetEntryHeaders(h http.Header, e kv.Entry) {
+	if !e.Version.IsZero() {
+		h.Set(VersionHeader, e.Version.String())
+	}
+	if e.Deleted {
+		h.Set(TombstoneHeader, "true")
+	}
+	if e.ExpiresAt != 0 {
+		h.Set(ExpiresHeader, strconv.FormatInt(e.ExpiresAt, 10))
+	}
+}
+
+// ParseEntryHeaders достает версию, признак удаления и срок жизни из заголовков.
+// Тело (значение) вызывающий читает сам.
+func ParseEntryHeaders(h http.Header) (kv.Entry, error) {
+	var e kv.Entry
+	if raw := h.Get(VersionHeader); raw != "" {
+		v, err := kv.ParseVersion(raw)
+		if err != nil {
+			return kv.Entry{}, err
+		}
+		e.Version = v
+	}
+	e.Deleted = h.Get(TombstoneHeader) == "true"
+	if raw := h.Get(ExpiresHeader); raw != "" {
+		exp, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return kv.Entry{}, fmt.Errorf("bad %s: %w", ExpiresHeader, err)
+		}
+		e.ExpiresAt = exp
+	}
+	return e, nil
+}
+
+func (c *Client) do(ctx context.Context, method, addr, path, key string, e kv.Entry) error {
+	resp, err := c.send(ctx, method, addr, path, key, e)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, addr, path, key string, e kv.Entry) (*http.Response, error) {
+	u := fmt.Sprintf("http://%s%s?key=%s", addr, path, url.QueryEscape(key))
+
+	var body io.Reader
+	if e.Value != nil {
+		body = bytes.NewReader(e.Value)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	SetEntryHeaders(req.Header, e)
+	return c.http.Do(req)
+}
@@ -0,0 +1,27 @@
+package peer
+
+import (
+	"context"
+
+	"kv-store/internal/gossip"
+)
+
+// Client реализует gossip.Transport поверх /internal/gossip/*
+
+func (c *Client) Ping(ctx context.Context, addr string, req gossip.PingRequest) (gossip.PingResponse, error) {
+	var resp gossip.PingResponse
+	err := c.postJSON(ctx, addr, "/internal/gossip/ping", req, &resp)
+	return resp, err
+}
+
+func (c *Client) IndirectPing(ctx context.Context, addr string, req gossip.IndirectPingRequest) (gossip.IndirectPingResponse, error) {
+	var resp gossip.IndirectPingResponse
+	err := c.postJSON(ctx, addr, "/internal/gossip/indirect-ping", req, &resp)
+	return resp, err
+}
+
+func (c *Client) Sync(ctx context.Context, addr string, req gossip.SyncRequest) (gossip.SyncResponse, error) {
+	var resp gossip.SyncResponse
+	err := c.postJSON(ctx, addr, "/internal/gossip/sync", req, &resp)
+	return resp, err
+}
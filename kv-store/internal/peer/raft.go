@@ -0,0 +1,60 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"kv-store/internal/raft"
+)
+
+// Client реализует raft.Transport поверх /internal/raft/*
+
+func (c *Client) RequestVote(ctx context.Context, addr string, req raft.VoteRequest) (raft.VoteResponse, error) {
+	var resp raft.VoteResponse
+	err := c.postJSON(ctx, addr, "/internal/raft/vote", req, &resp)
+	return resp, err
+}
+
+func (c *Client) AppendEntries(ctx context.Context, addr string, req raft.AppendRequest) (raft.AppendResponse, error) {
+	var resp raft.AppendResponse
+	err := c.postJSON(ctx, addr, "/internal/raft/append", req, &resp)
+	return resp, err
+}
+
+func (c *Client) InstallSnapshot(ctx context.Context, addr string, req raft.SnapshotRequest) (raft.SnapshotResponse, error) {
+	var resp raft.SnapshotResponse
+	err := c.postJSON(ctx, addr, "/internal/raft/snapshot", req, &resp)
+	return resp, err
+}
+
+func (c *Client) FindLeader(ctx context.Context, addr string, req raft.LeaderRequest) (raft.LeaderResponse, error) {
+	var resp raft.LeaderResponse
+	err := c.postJSON(ctx, addr, "/internal/raft/leader", req, &resp)
+	return resp, err
+}
+
+func (c *Client) postJSON(ctx context.Context, addr, path string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s%s", addr, path), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
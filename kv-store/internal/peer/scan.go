@@ -0,0 +1,92 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"kv-store/internal/kv"
+)
+
+// wireItem - запись с ключом в ответах /internal/scan и /internal/watch
+type wireItem struct {
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Version   string `json:"version"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+func toWire(it kv.Item) wireItem {
+	return wireItem{
+		Key:       it.Key,
+		Value:     it.Entry.Value,
+		Version:   it.Entry.Version.String(),
+		Deleted:   it.Entry.Deleted,
+		ExpiresAt: it.Entry.ExpiresAt,
+	}
+}
+
+func fromWire(it wireItem) (kv.Item, error) {
+	v, err := kv.ParseVersion(it.Version)
+	if err != nil {
+		return kv.Item{}, err
+	}
+	return kv.Item{Key: it.Key, Entry: kv.Entry{
+		Value:     it.Value,
+		Version:   v,
+		Deleted:   it.Deleted,
+		ExpiresAt: it.ExpiresAt,
+	}}, nil
+}
+
+// WriteItems отдает результат локального Scan в формате /internal/scan
+func WriteItems(w io.Writer, items []kv.Item) error {
+	out := make([]wireItem, 0, len(items))
+	for _, it := range items {
+		out = append(out, toWire(it))
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// Scan читает до limit локальных записей ноды addr (включая tombstone)
+// с ключами из [from, end) по возрастанию
+func (c *Client) Scan(ctx context.Context, addr, from, end string, limit int) ([]kv.Item, error) {
+	q := url.Values{}
+	q.Set("from", from)
+	q.Set("end", end)
+	q.Set("limit", strconv.Itoa(limit))
+	u := fmt.Sprintf("http://%s/internal/scan?%s", addr, q.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var raw []wireItem
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	items := make([]kv.Item, 0, len(raw))
+	for _, w := range raw {
+		it, err := fromWire(w)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
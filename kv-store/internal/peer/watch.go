@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"kv-store/internal/kv"
+)
+
+// ErrLagged - подписка на ноде переполнилась, часть событий потеряна
+var ErrLagged = errors.New("watch subscription lagged behind")
+
+// watchLine - строка потока /internal/watch: событие или отметка о том,
+// что подписка переполнилась и поток заканчивается
+type watchLine struct {
+	wireItem
+	Lagged bool `json:"lagged,omitempty"`
+}
+
+// WriteEvent пишет изменение ключа в поток /internal/watch (одна JSON-строка на событие)
+func WriteEvent(w io.Writer, ev kv.Event) error {
+	return json.NewEncoder(w).Encode(toWire(kv.Item{Key: ev.Key, Entry: ev.Entry}))
+}
+
+// WriteLagged пишет в поток /internal/watch отметку о переполнении подписки
+func WriteLagged(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		Lagged bool `json:"lagged"`
+	}{true})
+}
+
+// Watch читает поток изменений локального хранилища ноды addr для ключей
+// с префиксом prefix и вызывает fn на каждое событие. Возвращается, когда
+// поток оборвался или отменен ctx; ErrLagged - подписка на ноде переполнилась.
+func (c *Client) Watch(ctx context.Context, addr, prefix string, fn func(kv.Event)) error {
+	u := fmt.Sprintf("http://%s/internal/watch?prefix=%s", addr, url.QueryEscape(prefix))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		var line watchLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return err
+		}
+		if line.Lagged {
+			return ErrLagged
+		}
+		it, err := fromWire(line.wireItem)
+		if err != nil {
+			return err
+		}
+		fn(kv.Event{Key: it.Key, Entry: it.Entry})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
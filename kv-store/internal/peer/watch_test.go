@@ -0,0 +1,33 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kv-store/internal/kv"
+)
+
+func TestWatchReportsLagged(t *testing.T) {
+	ev := kv.Event{Key: "a", Entry: kv.Entry{Value: []byte("1"), Version: kv.Version{Wall: 1, Node: "n1"}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = WriteEvent(w, ev)
+		_ = WriteLagged(w)
+	}))
+	defer srv.Close()
+
+	var got []kv.Event
+	err := NewClient(time.Second).Watch(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "", func(e kv.Event) {
+		got = append(got, e)
+	})
+	if !errors.Is(err, ErrLagged) {
+		t.Fatalf("Watch: err = %v, want ErrLagged", err)
+	}
+	if len(got) != 1 || got[0].Key != "a" || string(got[0].Entry.Value) != "1" || got[0].Entry.Version != ev.Entry.Version {
+		t.Fatalf("events = %+v, want [%+v]", got, ev)
+	}
+}
@@ -0,0 +1,1026 @@
+package raft
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAppendEntries - сколько записей лидер отправляет за один AppendEntries
+	maxAppendEntries = 256
+
+	// compactEvery - после скольких примененных записей делать снапшот и сокращать лог
+	compactEvery = 4096
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// waiter ждет применения записи, предложенной лидером в сроке term
+type waiter struct {
+	term uint64
+	ch   chan result
+}
+
+// group - одна группа Raft на этой ноде
+type group struct {
+	id    int
+	host  *Host
+	store *storage
+
+	// applyMu держит тот, кто меняет конечный автомат группы
+	// (применение записей, установка снапшота) или снимает с него снапшот
+	applyMu sync.Mutex
+
+	mu      sync.Mutex
+	term    uint64
+	vote    string
+	snap    Snapshot // без Data: лог начинается после snap.Index
+	entries []Entry
+
+	role        role
+	leader      string
+	lastContact time.Time // когда последний раз слышали лидера
+	deadline    time.Time // когда начинать выборы
+	leaderSince time.Time
+	commit      uint64
+	applied     uint64
+	desired     []string // состав группы по данным discovery
+	// hint - лидер, о котором сообщил участник группы (когда эта нода в нее не входит)
+	hint   string
+	hintAt time.Time
+
+	// Состояние лидера
+	next    map[string]uint64
+	match   map[string]uint64
+	acked   map[string]time.Time // время отправки последнего AppendEntries, на который ответили
+	peers   map[string]*replicator
+	waiters map[uint64]waiter
+
+	// changed закрывается при каждом изменении commit, applied, acked или лидера
+	changed chan struct{}
+	// stopped - журнал закрыт, группа больше ничего не пишет и не отвечает
+	stopped bool
+}
+
+// replicator отправляет записи одному последователю
+type replicator struct {
+	wake chan struct{}
+	stop chan struct{}
+}
+
+func newGroup(id int, host *Host, store *storage, hs hardState) *group {
+	g := &group{
+		id:      id,
+		host:    host,
+		store:   store,
+		term:    hs.term,
+		vote:    hs.vote,
+		snap:    Snapshot{Index: hs.snap.Index, Term: hs.snap.Term, Members: hs.snap.Members},
+		entries: hs.entries,
+		commit:  hs.snap.Index,
+		applied: hs.snap.Index,
+		peers:   make(map[string]*replicator),
+		waiters: make(map[uint64]waiter),
+		changed: make(chan struct{}),
+	}
+	g.resetDeadline()
+	return g
+}
+
+func (g *group) lastIndex() uint64 {
+	return g.snap.Index + uint64(len(g.entries))
+}
+
+func (g *group) lastTerm() uint64 {
+	if len(g.entries) > 0 {
+		return g.entries[len(g.entries)-1].Term
+	}
+	return g.snap.Term
+}
+
+// termAt возвращает срок записи i; false - записи нет или она уже в снапшоте
+func (g *group) termAt(i uint64) (uint64, bool) {
+	switch {
+	case i == g.snap.Index:
+		return g.snap.Term, true
+	case i < g.snap.Index || i > g.lastIndex():
+		return 0, false
+	}
+	return g.entries[i-g.snap.Index-1].Term, true
+}
+
+func (g *group) entry(i uint64) Entry {
+	return g.entries[i-g.snap.Index-1]
+}
+
+// membersAt - состав группы по последней записи конфигурации с индексом <= i.
+// Новый состав действует сразу после добавления в лог, не дожидаясь фиксации.
+func (g *group) membersAt(i uint64) ([]string, uint64) {
+	for j := min(i, g.lastIndex()); j > g.snap.Index; j-- {
+		if e := g.entry(j); e.Type == EntryConfig {
+			return e.Members, j
+		}
+	}
+	return g.snap.Members, g.snap.Index
+}
+
+func (g *group) members() []string {
+	m, _ := g.membersAt(g.lastIndex())
+	return m
+}
+
+func (g *group) isMember(id string) bool {
+	return contains(g.members(), id)
+}
+
+func (g *group) empty() bool {
+	return g.lastIndex() == 0
+}
+
+// canCampaign: в выборах участвуют только члены группы. Пустую группу
+// создает одна заранее известная нода из состава по discovery (чтобы лидеры
+// разных групп оказались на разных нодах, она выбирается по номеру группы),
+// остальные ждут ее записей. Группа создается только полного размера: нода, которая пока
+// видит в кластере только себя, не должна создать свою отдельную группу.
+func (g *group) canCampaign() bool {
+	if !g.empty() {
+		return g.isMember(g.host.self)
+	}
+	return len(g.desired) >= g.host.bootstrapSize && g.desired[g.id%len(g.desired)] == g.host.self
+}
+
+// voters - состав, по которому считается кворум на выборах
+func (g *group) voters() []string {
+	if g.empty() {
+		return g.desired
+	}
+	return g.members()
+}
+
+func quorum(members []string) int {
+	return len(members)/2 + 1
+}
+
+func (g *group) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *group) resetDeadline() {
+	timeout := g.host.electionTimeout
+	g.deadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+func (g *group) saveState() {
+	if g.stopped {
+		return
+	}
+	if err := g.store.saveState(g.term, g.vote); err != nil {
+		log.Fatalf("raft group %d: persist state: %v", g.id, err)
+	}
+}
+
+func (g *group) saveEntries(entries []Entry) {
+	if g.stopped {
+		return
+	}
+	if err := g.store.saveEntries(entries); err != nil {
+		log.Fatalf("raft group %d: persist log: %v", g.id, err)
+	}
+}
+
+// tick вызывается периодически: запускает выборы по таймауту, а лидер
+// проверяет, что его слышит кворум, и меняет состав группы
+func (g *group) tick() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if g.stopped {
+		return
+	}
+	if g.role != leader {
+		if now.After(g.deadline) && g.canCampaign() {
+			g.campaign()
+		}
+		return
+	}
+
+	if !g.isMember(g.host.self) {
+		// Лидер, исключивший себя, уходит после фиксации новой конфигурации
+		if _, idx := g.membersAt(g.lastIndex()); idx <= g.commit {
+			log.Printf("Raft group %d: leader %s removed from the group, stepping down", g.id, g.host.self)
+			g.becomeFollower(g.term, "")
+		}
+		return
+	}
+
+	if now.Sub(g.leaderSince) > g.host.electionTimeout && !g.heardFromQuorum(now.Add(-g.host.electionTimeout)) {
+		log.Printf("Raft group %d: leader %s lost contact with quorum, stepping down", g.id, g.host.self)
+		g.becomeFollower(g.term, "")
+		return
+	}
+	g.dropRemoved()
+	g.reconfigure()
+}
+
+// heardFromQuorum: на запросы лидера, отправленные после since, ответил кворум
+func (g *group) heardFromQuorum(since time.Time) bool {
+	members := g.members()
+	n := 0
+	for _, id := range members {
+		if id == g.host.self || !g.acked[id].Before(since) {
+			n++
+		}
+	}
+	return n >= quorum(members)
+}
+
+func (g *group) becomeFollower(term uint64, leaderID string) {
+	if term > g.term {
+		g.term = term
+		g.vote = ""
+		g.saveState()
+	}
+	wasLeader := g.role == leader
+	g.role = follower
+	if g.leader != leaderID {
+		g.leader = leaderID
+		g.notify()
+	}
+	g.resetDeadline()
+
+	if wasLeader {
+		for id, rep := range g.peers {
+			close(rep.stop)
+			delete(g.peers, id)
+		}
+		for idx, w := range g.waiters {
+			w.ch <- result{err: ErrLeadershipLost}
+			delete(g.waiters, idx)
+		}
+		g.notify()
+	}
+}
+
+// campaign начинает выборы с предварительного голосования (pre-vote):
+// срок повышается, только если кворум готов проголосовать. Так нода,
+// отрезанная от группы или исключенная из нее, не сбивает живого лидера.
+func (g *group) campaign() {
+	g.resetDeadline()
+	g.requestVotes(true)
+}
+
+func (g *group) requestVotes(pre bool) {
+	if !pre {
+		g.role = candidate
+		g.term++
+		g.vote = g.host.self
+		g.leader = ""
+		g.saveState()
+		g.notify()
+	}
+	base := g.term
+	term := base
+	if pre {
+		term++
+	}
+
+	voters := g.voters()
+	votes := 0
+	won := func() {
+		if pre {
+			g.requestVotes(false)
+		} else {
+			g.becomeLeader()
+		}
+	}
+	if contains(voters, g.host.self) {
+		votes++
+	}
+	if votes >= quorum(voters) {
+		won()
+		return
+	}
+
+	req := VoteRequest{
+		Group:     g.id,
+		Term:      term,
+		Candidate: g.host.self,
+		LastIndex: g.lastIndex(),
+		LastTerm:  g.lastTerm(),
+		PreVote:   pre,
+	}
+	for _, id := range voters {
+		if id == g.host.self {
+			continue
+		}
+		go func(id string) {
+			addr, ok := g.host.resolve(id)
+			if !ok {
+				return
+			}
+			ctx, cancel := context.WithTimeout(g.host.ctx, g.host.electionTimeout)
+			defer cancel()
+			resp, err := g.host.transport.RequestVote(ctx, addr, req)
+			if err != nil {
+				return
+			}
+
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if resp.Term > g.term {
+				g.becomeFollower(resp.Term, "")
+				return
+			}
+			if !resp.Granted || g.term != base || g.role == leader || !pre && g.role != candidate {
+				return
+			}
+			votes++
+			if votes == quorum(voters) {
+				won()
+			}
+		}(id)
+	}
+}
+
+func (g *group) becomeLeader() {
+	log.Printf("Raft group %d: %s became leader for term %d", g.id, g.host.self, g.term)
+	g.role = leader
+	g.leader = g.host.self
+	g.leaderSince = time.Now()
+	g.next = make(map[string]uint64)
+	g.match = make(map[string]uint64)
+	g.acked = make(map[string]time.Time)
+
+	// Первая запись лидера фиксирует его срок; в пустой группе это ее начальный состав
+	e := Entry{Index: g.lastIndex() + 1, Term: g.term, Type: EntryNoop}
+	if g.empty() {
+		e.Type = EntryConfig
+		e.Members = append([]string(nil), g.desired...)
+	}
+	g.entries = append(g.entries, e)
+	g.saveEntries([]Entry{e})
+
+	for _, id := range g.members() {
+		g.startReplicator(id)
+	}
+	g.maybeCommit()
+	g.notify()
+}
+
+// reconfigure приводит состав группы к desired по одной ноде за раз:
+// следующее изменение - только после фиксации предыдущего и первой записи
+// своего срока. Иначе новый лидер может добавить изменение поверх
+// незафиксированного изменения прежнего лидера, и большинства старого
+// и нового состава не пересекутся.
+func (g *group) reconfigure() {
+	if len(g.desired) == 0 {
+		return
+	}
+	if t, _ := g.termAt(g.commit); t != g.term {
+		return
+	}
+	members, idx := g.membersAt(g.lastIndex())
+	if idx > g.commit {
+		return
+	}
+
+	var next []string
+	for _, id := range g.desired {
+		if !contains(members, id) {
+			next = append(append([]string(nil), members...), id)
+			break
+		}
+	}
+	if next == nil {
+		for i, id := range members {
+			if !contains(g.desired, id) {
+				next = append(append([]string(nil), members[:i]...), members[i+1:]...)
+				break
+			}
+		}
+	}
+	if next == nil {
+		return
+	}
+	sort.Strings(next)
+	log.Printf("Raft group %d: changing members %v -> %v", g.id, members, next)
+
+	e := Entry{Index: g.lastIndex() + 1, Term: g.term, Type: EntryConfig, Members: next}
+	g.entries = append(g.entries, e)
+	g.saveEntries([]Entry{e})
+
+	// Исключенной ноде записи отправляются, пока она не получит эту конфигурацию
+	// (см. dropRemoved): так она узнает, что вышла из группы
+	for _, id := range next {
+		g.startReplicator(id)
+	}
+	g.maybeCommit()
+	g.wakeAll()
+}
+
+// dropRemoved останавливает отправку записей нодам, исключенным из группы,
+// когда они получили запись об этом или пропали из кластера
+func (g *group) dropRemoved() {
+	members, idx := g.membersAt(g.lastIndex())
+	for id, rep := range g.peers {
+		if contains(members, id) {
+			continue
+		}
+		if _, ok := g.host.resolve(id); ok && g.match[id] < idx {
+			continue
+		}
+		close(rep.stop)
+		delete(g.peers, id)
+	}
+}
+
+// knownLeader - лидер, которого нода знает сама: она лидер или недавно его слышала
+func (g *group) knownLeader() string {
+	switch {
+	case g.role == leader:
+		return g.host.self
+	case g.leader != "" && time.Since(g.lastContact) < g.host.electionTimeout:
+		return g.leader
+	}
+	return ""
+}
+
+// contacts - у кого можно спросить лидера: участники по discovery и по логу
+func (g *group) contacts() []string {
+	var ids []string
+	for _, id := range append(append([]string(nil), g.desired...), g.members()...) {
+		if id != g.host.self && !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// waitRemoved ждет, пока нода получит конфигурацию группы без себя и,
+// если была лидером, передаст лидерство
+func (g *group) waitRemoved(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.isMember(g.host.self) || g.role == leader {
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(g.host.heartbeatInterval):
+		case <-ctx.Done():
+		case <-g.host.ctx.Done():
+		}
+		g.mu.Lock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if g.host.ctx.Err() != nil {
+			return ErrStopped
+		}
+	}
+	return nil
+}
+
+func (g *group) startReplicator(id string) {
+	if id == g.host.self {
+		return
+	}
+	if _, ok := g.peers[id]; ok {
+		return
+	}
+	rep := &replicator{wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	g.peers[id] = rep
+	g.next[id] = g.lastIndex() + 1
+	g.match[id] = 0
+	go g.replicate(id, g.term, rep)
+}
+
+func (g *group) wakeAll() {
+	for _, rep := range g.peers {
+		select {
+		case rep.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate отправляет последователю id новые записи, а при их отсутствии -
+// пустой AppendEntries раз в heartbeatInterval
+func (g *group) replicate(id string, term uint64, rep *replicator) {
+	heartbeat := time.NewTicker(g.host.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		more, ok := g.sendTo(id, term)
+		if !ok {
+			return
+		}
+		if more {
+			select {
+			case <-rep.stop:
+				return
+			default:
+			}
+			continue
+		}
+		select {
+		case <-g.host.ctx.Done():
+			return
+		case <-rep.stop:
+			return
+		case <-rep.wake:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// sendTo выполняет один раунд репликации. more - у последователя еще
+// не все записи, ok = false - нода больше не лидер срока term.
+func (g *group) sendTo(id string, term uint64) (more, ok bool) {
+	addr, found := g.host.resolve(id)
+
+	g.mu.Lock()
+	if g.role != leader || g.term != term {
+		g.mu.Unlock()
+		return false, false
+	}
+	if !found {
+		g.mu.Unlock()
+		return false, true
+	}
+	next := g.next[id]
+	prevTerm, has := g.termAt(next - 1)
+	if !has {
+		// Нужные записи уже в снапшоте
+		g.mu.Unlock()
+		return g.sendSnapshot(id, addr, term)
+	}
+	last := min(g.lastIndex(), next-1+maxAppendEntries)
+	var entries []Entry
+	if next <= last {
+		entries = append(entries, g.entries[next-g.snap.Index-1:last-g.snap.Index]...)
+	}
+	req := AppendRequest{
+		Group:     g.id,
+		Term:      term,
+		Leader:    g.host.self,
+		PrevIndex: next - 1,
+		PrevTerm:  prevTerm,
+		Entries:   entries,
+		Commit:    g.commit,
+	}
+	g.mu.Unlock()
+
+	sentAt := time.Now()
+	ctx, cancel := context.WithTimeout(g.host.ctx, g.host.electionTimeout)
+	resp, err := g.host.transport.AppendEntries(ctx, addr, req)
+	cancel()
+	if err != nil {
+		return false, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if resp.Term > g.term {
+		g.becomeFollower(resp.Term, "")
+		return false, false
+	}
+	if g.role != leader || g.term != term {
+		return false, false
+	}
+	if sentAt.After(g.acked[id]) {
+		g.acked[id] = sentAt
+		g.notify()
+	}
+
+	if resp.Success {
+		if m := req.PrevIndex + uint64(len(entries)); m > g.match[id] {
+			g.match[id] = m
+			g.next[id] = m + 1
+			g.maybeCommit()
+		}
+	} else {
+		g.next[id] = max(1, min(g.next[id]-1, resp.LastIndex+1))
+	}
+	return g.next[id] <= g.lastIndex(), true
+}
+
+// sendSnapshot передает последователю снапшот текущего состояния автомата
+func (g *group) sendSnapshot(id, addr string, term uint64) (more, ok bool) {
+	snap, err := g.takeSnapshot()
+	if err != nil {
+		log.Printf("ERR: Raft group %d: snapshot for %s failed: %v", g.id, id, err)
+		return false, true
+	}
+
+	ctx, cancel := context.WithTimeout(g.host.ctx, 10*g.host.electionTimeout)
+	resp, err := g.host.transport.InstallSnapshot(ctx, addr, SnapshotRequest{
+		Group:    g.id,
+		Term:     term,
+		Leader:   g.host.self,
+		Snapshot: snap,
+	})
+	cancel()
+	if err != nil {
+		log.Printf("ERR: Raft group %d: install snapshot on %s failed: %v", g.id, id, err)
+		return false, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if resp.Term > g.term {
+		g.becomeFollower(resp.Term, "")
+		return false, false
+	}
+	if g.role != leader || g.term != term {
+		return false, false
+	}
+	if snap.Index > g.match[id] {
+		g.match[id] = snap.Index
+		g.next[id] = snap.Index + 1
+		g.maybeCommit()
+	}
+	return g.next[id] <= g.lastIndex(), true
+}
+
+// maybeCommit двигает commit до последней записи текущего срока,
+// которая есть у кворума
+func (g *group) maybeCommit() {
+	members := g.members()
+	for n := g.lastIndex(); n > g.commit; n-- {
+		if t, _ := g.termAt(n); t != g.term {
+			break
+		}
+		count := 0
+		for _, id := range members {
+			if id == g.host.self || g.match[id] >= n {
+				count++
+			}
+		}
+		if count >= quorum(members) {
+			g.commit = n
+			g.notify()
+			g.wakeAll()
+			return
+		}
+	}
+}
+
+func (g *group) handleVote(req VoteRequest) (VoteResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return VoteResponse{}, ErrStopped
+	}
+
+	upToDate := req.LastTerm > g.lastTerm() || req.LastTerm == g.lastTerm() && req.LastIndex >= g.lastIndex()
+	leaderAlive := g.role == leader || g.leader != "" && time.Since(g.lastContact) < g.host.electionTimeout
+
+	// Предварительное голосование ничего не меняет: нода только сообщает,
+	// проголосовала бы она за кандидата
+	if req.PreVote {
+		return VoteResponse{Term: g.term, Granted: req.Term > g.term && upToDate && !leaderAlive}, nil
+	}
+
+	// Пока лидер жив, кандидаты не сбивают его повышением срока
+	if req.Term < g.term || req.Term > g.term && leaderAlive {
+		return VoteResponse{Term: g.term}, nil
+	}
+	if req.Term > g.term {
+		g.becomeFollower(req.Term, "")
+	}
+
+	if (g.vote == "" || g.vote == req.Candidate) && upToDate {
+		g.vote = req.Candidate
+		g.saveState()
+		g.resetDeadline()
+		return VoteResponse{Term: g.term, Granted: true}, nil
+	}
+	return VoteResponse{Term: g.term}, nil
+}
+
+func (g *group) handleAppend(req AppendRequest) (AppendResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return AppendResponse{}, ErrStopped
+	}
+
+	if req.Term < g.term {
+		return AppendResponse{Term: g.term, LastIndex: g.lastIndex()}, nil
+	}
+	if req.Term > g.term || g.role != follower || g.leader != req.Leader {
+		g.becomeFollower(req.Term, req.Leader)
+	}
+	g.lastContact = time.Now()
+	g.resetDeadline()
+
+	if req.PrevIndex > g.lastIndex() {
+		return AppendResponse{Term: g.term, LastIndex: g.lastIndex()}, nil
+	}
+	if req.PrevIndex >= g.snap.Index {
+		if t, _ := g.termAt(req.PrevIndex); t != req.PrevTerm {
+			return AppendResponse{Term: g.term, LastIndex: req.PrevIndex - 1}, nil
+		}
+	}
+
+	var added []Entry
+	for i, e := range req.Entries {
+		if e.Index <= g.snap.Index {
+			continue
+		}
+		if e.Index <= g.lastIndex() {
+			if t, _ := g.termAt(e.Index); t == e.Term {
+				continue
+			}
+			// Конфликт: хвост лога после последней согласованной записи отбрасывается
+			g.entries = g.entries[:e.Index-g.snap.Index-1]
+		}
+		added = req.Entries[i:]
+		break
+	}
+	if len(added) > 0 {
+		g.entries = append(g.entries, added...)
+		g.saveEntries(added)
+	}
+
+	if last := req.PrevIndex + uint64(len(req.Entries)); req.Commit > g.commit {
+		g.commit = max(g.commit, min(req.Commit, last))
+		g.notify()
+	}
+	return AppendResponse{Term: g.term, Success: true, LastIndex: g.lastIndex()}, nil
+}
+
+func (g *group) handleSnapshot(req SnapshotRequest) (SnapshotResponse, error) {
+	g.mu.Lock()
+	if g.stopped {
+		defer g.mu.Unlock()
+		return SnapshotResponse{}, ErrStopped
+	}
+	if req.Term < g.term {
+		defer g.mu.Unlock()
+		return SnapshotResponse{Term: g.term}, nil
+	}
+	if req.Term > g.term || g.role != follower || g.leader != req.Leader {
+		g.becomeFollower(req.Term, req.Leader)
+	}
+	g.lastContact = time.Now()
+	g.resetDeadline()
+	g.mu.Unlock()
+
+	g.applyMu.Lock()
+	defer g.applyMu.Unlock()
+
+	g.mu.Lock()
+	snap := req.Snapshot
+	if g.stopped {
+		defer g.mu.Unlock()
+		return SnapshotResponse{}, ErrStopped
+	}
+	if snap.Index <= g.applied {
+		defer g.mu.Unlock()
+		return SnapshotResponse{Term: g.term}, nil
+	}
+	if err := g.store.saveSnapshot(snap); err != nil {
+		log.Fatalf("raft group %d: persist snapshot: %v", g.id, err)
+	}
+	g.entries = entriesAfter(g.snap, g.entries, snap)
+	g.snap = Snapshot{Index: snap.Index, Term: snap.Term, Members: snap.Members}
+	g.commit = max(g.commit, snap.Index)
+	term := g.term
+	g.mu.Unlock()
+
+	if err := g.host.fsm.Restore(g.id, snap.Data); err != nil {
+		log.Fatalf("raft group %d: restore snapshot: %v", g.id, err)
+	}
+	log.Printf("Raft group %d: installed snapshot at index %d from %s", g.id, snap.Index, req.Leader)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.applied = snap.Index
+	g.notify()
+	return SnapshotResponse{Term: term}, nil
+}
+
+// propose добавляет команду в лог лидера и ждет ее применения
+func (g *group) propose(ctx context.Context, data []byte) (interface{}, error) {
+	g.mu.Lock()
+	if g.stopped {
+		g.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if g.role != leader {
+		g.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	e := Entry{Index: g.lastIndex() + 1, Term: g.term, Data: data}
+	g.entries = append(g.entries, e)
+	g.saveEntries([]Entry{e})
+
+	ch := make(chan result, 1)
+	g.waiters[e.Index] = waiter{term: e.Term, ch: ch}
+	g.maybeCommit()
+	g.wakeAll()
+	g.mu.Unlock()
+
+	select {
+	case res := <-ch:
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readIndex подтверждает, что нода все еще лидер, и ждет, пока автомат
+// догонит commit на момент запроса. После этого локальное чтение линеаризуемо.
+func (g *group) readIndex(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.role != leader {
+		return ErrNotLeader
+	}
+	term := g.term
+
+	// Новый лидер знает commit только после фиксации записи своего срока
+	for {
+		if t, _ := g.termAt(g.commit); t == term {
+			break
+		}
+		if err := g.waitLocked(ctx, term); err != nil {
+			return err
+		}
+	}
+	index := g.commit
+
+	start := time.Now()
+	g.wakeAll()
+	for !g.heardFromQuorum(start) {
+		if err := g.waitLocked(ctx, term); err != nil {
+			return err
+		}
+	}
+	for g.applied < index {
+		if err := g.waitLocked(ctx, term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitLocked ждет следующего изменения состояния группы
+func (g *group) waitLocked(ctx context.Context, term uint64) error {
+	changed := g.changed
+	g.mu.Unlock()
+	select {
+	case <-changed:
+	case <-ctx.Done():
+	case <-g.host.ctx.Done():
+	}
+	g.mu.Lock()
+
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case g.host.ctx.Err() != nil:
+		return ErrStopped
+	case g.role != leader || g.term != term:
+		return ErrLeadershipLost
+	}
+	return nil
+}
+
+// applyLoop применяет зафиксированные записи к автомату и отдает
+// результаты ожидающим propose
+func (g *group) applyLoop() {
+	for {
+		g.mu.Lock()
+		for g.applied >= g.commit {
+			changed := g.changed
+			g.mu.Unlock()
+			select {
+			case <-changed:
+			case <-g.host.ctx.Done():
+				return
+			}
+			g.mu.Lock()
+		}
+		g.mu.Unlock()
+
+		g.applyCommitted()
+	}
+}
+
+func (g *group) applyCommitted() {
+	g.applyMu.Lock()
+	defer g.applyMu.Unlock()
+
+	g.mu.Lock()
+	from, to := g.applied+1, g.commit
+	if g.stopped || from > to {
+		g.mu.Unlock()
+		return
+	}
+	batch := append([]Entry(nil), g.entries[from-g.snap.Index-1:to-g.snap.Index]...)
+	g.mu.Unlock()
+
+	results := make([]interface{}, len(batch))
+	for i, e := range batch {
+		if e.Type == EntryNormal {
+			results[i] = g.host.fsm.Apply(g.id, e.Data)
+		}
+	}
+
+	g.mu.Lock()
+	g.applied = to
+	for i, e := range batch {
+		w, ok := g.waiters[e.Index]
+		if !ok {
+			continue
+		}
+		delete(g.waiters, e.Index)
+		if w.term == e.Term {
+			w.ch <- result{value: results[i]}
+		} else {
+			w.ch <- result{err: ErrLeadershipLost}
+		}
+	}
+	g.notify()
+	compact := g.applied-g.snap.Index >= compactEvery
+	g.mu.Unlock()
+
+	if compact {
+		if err := g.compact(); err != nil {
+			log.Printf("ERR: Raft group %d: log compaction failed: %v", g.id, err)
+		}
+	}
+}
+
+// takeSnapshot снимает снапшот автомата на момент applied
+func (g *group) takeSnapshot() (Snapshot, error) {
+	g.applyMu.Lock()
+	defer g.applyMu.Unlock()
+	return g.snapshotLocked()
+}
+
+// snapshotLocked требует applyMu
+func (g *group) snapshotLocked() (Snapshot, error) {
+	g.mu.Lock()
+	index := g.applied
+	term, _ := g.termAt(index)
+	members, _ := g.membersAt(index)
+	g.mu.Unlock()
+
+	data, err := g.host.fsm.Snapshot(g.id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Index: index, Term: term, Members: members, Data: data}, nil
+}
+
+// compact сохраняет снапшот и удаляет из лога записи до него. Требует applyMu.
+func (g *group) compact() error {
+	snap, err := g.snapshotLocked()
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped || snap.Index <= g.snap.Index {
+		return nil
+	}
+	entries := append([]Entry(nil), g.entries[snap.Index-g.snap.Index:]...)
+	if err := g.store.compact(g.term, g.vote, snap, entries); err != nil {
+		return err
+	}
+	g.entries = entries
+	g.snap = Snapshot{Index: snap.Index, Term: snap.Term, Members: snap.Members}
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestLeader - лидер срока term группы, чей лог заканчивается entries,
+// а ноды, кроме self, недоступны
+func newTestLeader(t *testing.T, term uint64, entries []Entry, commit uint64) *group {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	host := &Host{
+		self:              "n1",
+		resolve:           func(string) (string, bool) { return "", false },
+		heartbeatInterval: time.Hour,
+		electionTimeout:   time.Second,
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+	g := newGroup(0, host, &storage{}, hardState{term: term, entries: entries})
+	g.commit = commit
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.becomeLeader()
+	return g
+}
+
+func TestReconfigureWaitsForLeaderTermCommit(t *testing.T) {
+	config := Entry{Index: 1, Term: 1, Type: EntryConfig, Members: []string{"n1", "n2", "n3"}}
+	g := newTestLeader(t, 2, []Entry{config}, 1)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.desired = []string{"n1", "n2", "n3", "n4"}
+
+	// Конфигурация прежнего срока зафиксирована, но noop нового лидера - нет
+	g.reconfigure()
+	if last := g.entry(g.lastIndex()); last.Type != EntryNoop {
+		t.Fatalf("config change appended before the leader's noop committed: %+v", last)
+	}
+
+	// Noop подтвердил кворум
+	g.match["n2"] = g.lastIndex()
+	g.maybeCommit()
+	if g.commit != 2 {
+		t.Fatalf("commit = %d, want 2", g.commit)
+	}
+	g.reconfigure()
+	last := g.entry(g.lastIndex())
+	if last.Type != EntryConfig || len(last.Members) != 4 {
+		t.Fatalf("config change not appended after noop commit: %+v", last)
+	}
+
+	// Следующее изменение - только после фиксации этого
+	g.desired = append(g.desired, "n5")
+	g.reconfigure()
+	if g.lastIndex() != last.Index {
+		t.Fatalf("second config change appended before the first committed")
+	}
+}
@@ -0,0 +1,277 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	// Groups - число групп (шардов); не должно меняться между запусками
+	Groups int
+	// Self - id этой ноды
+	Self string
+	// Dir - каталог журналов групп; пустой - только в памяти
+	Dir string
+	// BootstrapSize - сколько нод должно быть в составе пустой группы,
+	// чтобы ее можно было создать
+	BootstrapSize int
+
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration
+}
+
+// Host ведет все группы Raft на ноде. Каждая группа независима:
+// у нее свой лидер, лог и состав.
+type Host struct {
+	self              string
+	fsm               StateMachine
+	transport         Transport
+	resolve           func(id string) (string, bool)
+	heartbeatInterval time.Duration
+	electionTimeout   time.Duration
+	bootstrapSize     int
+
+	groups []*group
+	stores []*storage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewHost открывает журналы групп и восстанавливает автомат из их снапшотов.
+// resolve возвращает адрес ноды по id.
+func NewHost(cfg Config, fsm StateMachine, transport Transport, resolve func(id string) (string, bool)) (*Host, error) {
+	if cfg.Groups <= 0 {
+		return nil, fmt.Errorf("raft: invalid number of groups %d", cfg.Groups)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Host{
+		self:              cfg.Self,
+		fsm:               fsm,
+		transport:         transport,
+		resolve:           resolve,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		electionTimeout:   cfg.ElectionTimeout,
+		bootstrapSize:     max(cfg.BootstrapSize, 1),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+
+	start := time.Now()
+	entries := 0
+	for i := 0; i < cfg.Groups; i++ {
+		dir := ""
+		if cfg.Dir != "" {
+			dir = filepath.Join(cfg.Dir, strconv.Itoa(i))
+		}
+		store, hs, err := openStorage(dir)
+		if err != nil {
+			h.closeStores()
+			return nil, fmt.Errorf("raft group %d: %w", i, err)
+		}
+		h.stores = append(h.stores, store)
+
+		// Записи после снапшота применятся заново, когда лидер сообщит commit
+		if err := fsm.Restore(i, hs.snap.Data); err != nil {
+			h.closeStores()
+			return nil, fmt.Errorf("raft group %d: restore snapshot: %w", i, err)
+		}
+		hs.snap.Data = nil
+		entries += len(hs.entries)
+		h.groups = append(h.groups, newGroup(i, h, store, hs))
+	}
+	log.Printf("Raft: opened %d groups (%d log entries) in %v", cfg.Groups, entries, time.Since(start))
+	return h, nil
+}
+
+func (h *Host) Start() {
+	log.Println("Raft host started")
+	for _, g := range h.groups {
+		go g.applyLoop()
+	}
+
+	ticker := time.NewTicker(h.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, g := range h.groups {
+				g.tick()
+			}
+		}
+	}
+}
+
+// Stop останавливает группы, дожидается применения уже начатых записей
+// и закрывает журналы
+func (h *Host) Stop() {
+	h.cancel()
+	for _, g := range h.groups {
+		g.mu.Lock()
+		g.stopped = true
+		g.mu.Unlock()
+
+		g.applyMu.Lock()
+		g.applyMu.Unlock()
+	}
+	h.closeStores()
+}
+
+func (h *Host) closeStores() {
+	for _, s := range h.stores {
+		if err := s.close(); err != nil {
+			log.Printf("ERR: Raft log close failed: %v", err)
+		}
+	}
+}
+
+// Groups возвращает число групп
+func (h *Host) Groups() int {
+	return len(h.groups)
+}
+
+// SetMembers задает желаемый состав группы (по данным discovery).
+// Лидер группы приводит к нему фактический состав по одной ноде за раз.
+func (h *Host) SetMembers(group int, members []string) {
+	desired := append([]string(nil), members...)
+	sort.Strings(desired)
+
+	g := h.groups[group]
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.desired = desired
+}
+
+// Leave ждет, пока нода выйдет из состава всех групп. Вызывается при выводе
+// ноды из кластера, после того как в SetMembers передан состав без нее.
+func (h *Host) Leave(ctx context.Context) error {
+	for _, g := range h.groups {
+		if err := g.waitRemoved(ctx); err != nil {
+			return fmt.Errorf("raft group %d: %w", g.id, err)
+		}
+	}
+	return nil
+}
+
+// Leader ждет, пока у группы появится известный лидер, и возвращает его id.
+// Нода вне группы не получает ее heartbeat и спрашивает лидера у участников.
+func (h *Host) Leader(ctx context.Context, group int) (string, error) {
+	g := h.groups[group]
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for {
+		if id := g.knownLeader(); id != "" {
+			return id, nil
+		}
+		if !g.isMember(h.self) {
+			if g.hint != "" && time.Since(g.hintAt) < h.electionTimeout {
+				return g.hint, nil
+			}
+			contacts := g.contacts()
+			g.mu.Unlock()
+			id := h.findLeader(ctx, group, contacts)
+			g.mu.Lock()
+			if id != "" {
+				g.hint, g.hintAt = id, time.Now()
+				return id, nil
+			}
+		}
+
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(h.heartbeatInterval):
+		case <-ctx.Done():
+		case <-h.ctx.Done():
+		}
+		g.mu.Lock()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if h.ctx.Err() != nil {
+			return "", ErrStopped
+		}
+	}
+}
+
+// findLeader опрашивает участников группы, пока кто-то не назовет лидера
+func (h *Host) findLeader(ctx context.Context, group int, contacts []string) string {
+	for _, id := range contacts {
+		addr, ok := h.resolve(id)
+		if !ok {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, h.electionTimeout)
+		resp, err := h.transport.FindLeader(callCtx, addr, LeaderRequest{Group: group})
+		cancel()
+		if err == nil && resp.Leader != "" && resp.Leader != h.self {
+			return resp.Leader
+		}
+	}
+	return ""
+}
+
+// Propose добавляет команду в лог группы и возвращает результат ее
+// применения к автомату. Работает только на лидере (иначе ErrNotLeader).
+func (h *Host) Propose(ctx context.Context, group int, data []byte) (interface{}, error) {
+	return h.groups[group].propose(ctx, data)
+}
+
+// ReadIndex подтверждает лидерство и ждет, пока автомат группы применит все
+// зафиксированные до вызова записи. После этого чтение автомата линеаризуемо.
+func (h *Host) ReadIndex(ctx context.Context, group int) error {
+	return h.groups[group].readIndex(ctx)
+}
+
+func (h *Host) RequestVote(req VoteRequest) (VoteResponse, error) {
+	g, err := h.group(req.Group)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	return g.handleVote(req)
+}
+
+func (h *Host) AppendEntries(req AppendRequest) (AppendResponse, error) {
+	g, err := h.group(req.Group)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	return g.handleAppend(req)
+}
+
+func (h *Host) InstallSnapshot(req SnapshotRequest) (SnapshotResponse, error) {
+	g, err := h.group(req.Group)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+	return g.handleSnapshot(req)
+}
+
+// FindLeader отвечает ноде вне группы, кто ее лидер
+func (h *Host) FindLeader(req LeaderRequest) (LeaderResponse, error) {
+	g, err := h.group(req.Group)
+	if err != nil {
+		return LeaderResponse{}, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return LeaderResponse{}, ErrStopped
+	}
+	return LeaderResponse{Leader: g.knownLeader()}, nil
+}
+
+func (h *Host) group(id int) (*group, error) {
+	if id < 0 || id >= len(h.groups) {
+		return nil, fmt.Errorf("raft: unknown group %d", id)
+	}
+	return h.groups[id], nil
+}
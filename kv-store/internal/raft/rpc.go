@@ -0,0 +1,120 @@
+// Package raft реализует консенсус Raft для набора независимых групп
+// (по одной на шард ключей): выборы лидера, репликацию лога, снапшоты и
+// изменение состава группы по одной ноде за раз.
+package raft
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNotLeader - нода не лидер группы; запрос нужно отправить лидеру
+	ErrNotLeader = errors.New("raft: not the group leader")
+
+	// ErrLeadershipLost - лидер сменился, пока запись ждала фиксации.
+	// Запись могла как зафиксироваться, так и потеряться.
+	ErrLeadershipLost = errors.New("raft: leadership lost, outcome unknown")
+
+	ErrStopped = errors.New("raft: stopped")
+)
+
+// EntryType - тип записи лога
+type EntryType uint8
+
+const (
+	EntryNormal EntryType = iota // команда конечного автомата
+	EntryNoop                    // пустая запись нового лидера, фиксирует его срок
+	EntryConfig                  // новый состав группы (Members)
+)
+
+// Entry - запись лога группы
+type Entry struct {
+	Index   uint64    `json:"index"`
+	Term    uint64    `json:"term"`
+	Type    EntryType `json:"type,omitempty"`
+	Data    []byte    `json:"data,omitempty"`
+	Members []string  `json:"members,omitempty"`
+}
+
+// Snapshot - состояние конечного автомата группы на момент Index.
+// Записи лога до Index включительно после снапшота не нужны.
+type Snapshot struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Members []string `json:"members,omitempty"`
+	Data    []byte   `json:"data,omitempty"`
+}
+
+type VoteRequest struct {
+	Group     int    `json:"group"`
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+	// PreVote - предварительное голосование: голосующий не меняет свой срок и голос
+	PreVote bool `json:"pre_vote,omitempty"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Group     int     `json:"group"`
+	Term      uint64  `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex uint64  `json:"prev_index"`
+	PrevTerm  uint64  `json:"prev_term"`
+	Entries   []Entry `json:"entries,omitempty"`
+	Commit    uint64  `json:"commit"`
+}
+
+// AppendResponse. LastIndex - последний индекс лога последователя,
+// по нему лидер быстрее находит точку расхождения.
+type AppendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+type SnapshotRequest struct {
+	Group    int      `json:"group"`
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// LeaderRequest - нода вне группы спрашивает участника, кто лидер
+type LeaderRequest struct {
+	Group int `json:"group"`
+}
+
+type LeaderResponse struct {
+	// Leader - id лидера; пустой, если участник его сейчас не знает
+	Leader string `json:"leader"`
+}
+
+// Transport доставляет RPC другим нодам по адресу
+type Transport interface {
+	RequestVote(ctx context.Context, addr string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(ctx context.Context, addr string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(ctx context.Context, addr string, req SnapshotRequest) (SnapshotResponse, error)
+	FindLeader(ctx context.Context, addr string, req LeaderRequest) (LeaderResponse, error)
+}
+
+// StateMachine - конечный автомат, к которому применяются зафиксированные
+// команды. Apply должен быть детерминированным: все ноды группы применяют
+// одни и те же команды в одном порядке и должны прийти к одному состоянию.
+type StateMachine interface {
+	Apply(group int, data []byte) interface{}
+	// Snapshot сериализует состояние группы
+	Snapshot(group int) ([]byte, error)
+	// Restore заменяет состояние группы снапшотом; nil - пустое состояние
+	Restore(group int, data []byte) error
+}
@@ -0,0 +1,137 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"kv-store/internal/wal"
+)
+
+// record - запись журнала группы на диске
+type record struct {
+	Term     uint64    `json:"term,omitempty"`
+	Vote     string    `json:"vote,omitempty"`
+	State    bool      `json:"state,omitempty"` // запись содержит term и vote
+	Entries  []Entry   `json:"entries,omitempty"`
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+}
+
+// hardState - то, что группа восстанавливает после рестарта
+type hardState struct {
+	term    uint64
+	vote    string
+	snap    Snapshot
+	entries []Entry // записи после snap.Index
+}
+
+// storage хранит term, голос, записи лога и последний снапшот группы в WAL
+// (fsync на каждую запись). nil wal - только в памяти.
+type storage struct {
+	wal *wal.Log
+}
+
+func openStorage(dir string) (*storage, hardState, error) {
+	var hs hardState
+	if dir == "" {
+		return &storage{}, hs, nil
+	}
+
+	journal, err := wal.Open(wal.Options{Dir: dir, Sync: wal.SyncAlways}, func(_ uint64, payload []byte) error {
+		var rec record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return fmt.Errorf("raft log: %w", err)
+		}
+		hs.apply(rec)
+		return nil
+	})
+	if err != nil {
+		return nil, hardState{}, err
+	}
+	return &storage{wal: journal}, hs, nil
+}
+
+// apply проигрывает запись журнала так же, как ее применяла группа
+func (hs *hardState) apply(rec record) {
+	if rec.State {
+		hs.term, hs.vote = rec.Term, rec.Vote
+	}
+	if s := rec.Snapshot; s != nil {
+		hs.entries = entriesAfter(hs.snap, hs.entries, *s)
+		hs.snap = *s
+	}
+	for _, e := range rec.Entries {
+		if e.Index <= hs.snap.Index {
+			continue
+		}
+		// Запись с индексом i заменяет конфликтующий хвост, начиная с i
+		if n := int(e.Index - hs.snap.Index - 1); n < len(hs.entries) {
+			hs.entries = hs.entries[:n]
+		}
+		hs.entries = append(hs.entries, e)
+	}
+}
+
+// entriesAfter оставляет записи после нового снапшота, если лог с ним согласован
+func entriesAfter(old Snapshot, entries []Entry, s Snapshot) []Entry {
+	if s.Index <= old.Index {
+		return entries
+	}
+	n := int(s.Index - old.Index)
+	if n > len(entries) || entries[n-1].Term != s.Term {
+		return nil
+	}
+	return append([]Entry(nil), entries[n:]...)
+}
+
+func (s *storage) saveState(term uint64, vote string) error {
+	return s.append(record{State: true, Term: term, Vote: vote})
+}
+
+func (s *storage) saveEntries(entries []Entry) error {
+	return s.append(record{Entries: entries})
+}
+
+func (s *storage) saveSnapshot(snap Snapshot) error {
+	return s.append(record{Snapshot: &snap})
+}
+
+// compact переписывает журнал: состояние, снапшот и записи после него
+// попадают в новый сегмент, старые сегменты удаляются
+func (s *storage) compact(term uint64, vote string, snap Snapshot, entries []Entry) error {
+	if s.wal == nil {
+		return nil
+	}
+	if err := s.wal.Roll(); err != nil {
+		return err
+	}
+	upTo := s.wal.LastSeq()
+	if err := s.append(record{State: true, Term: term, Vote: vote, Snapshot: &snap}); err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		if err := s.saveEntries(entries); err != nil {
+			return err
+		}
+	}
+	_, err := s.wal.TruncateBefore(upTo)
+	return err
+}
+
+func (s *storage) close() error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.Close()
+}
+
+func (s *storage) append(rec record) error {
+	if s.wal == nil {
+		return nil
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.wal.Append(payload)
+	return err
+}
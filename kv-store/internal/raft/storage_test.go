@@ -0,0 +1,146 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// logOf строит лог с записями 1..n в сроках terms
+func logOf(terms ...uint64) []Entry {
+	out := make([]Entry, len(terms))
+	for i, t := range terms {
+		out[i] = Entry{Index: uint64(i + 1), Term: t}
+	}
+	return out
+}
+
+// termsOf записывает лог как "индекс:срок ..."
+func termsOf(entries []Entry) string {
+	var s string
+	for _, e := range entries {
+		s += fmt.Sprintf("%d:%d ", e.Index, e.Term)
+	}
+	return s
+}
+
+func openTestStorage(t *testing.T, dir string) (*storage, hardState) {
+	t.Helper()
+	s, hs, err := openStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s, hs
+}
+
+func TestReplayTruncatesConflictingTail(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestStorage(t, dir)
+	if err := s.saveEntries(logOf(1, 1, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// Новый лидер перезаписал лог начиная с индекса 3
+	if err := s.saveEntries([]Entry{{Index: 3, Term: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	_, hs := openTestStorage(t, dir)
+	if got := termsOf(hs.entries); got != "1:1 2:1 3:2 " {
+		t.Fatalf("entries after replay: %q", got)
+	}
+}
+
+func TestReplaySnapshot(t *testing.T) {
+	cases := []struct {
+		name string
+		snap Snapshot
+		want string
+	}{
+		// Лог согласован со снапшотом: остаются записи после него
+		{"matching", Snapshot{Index: 2, Term: 1}, "3:2 4:2 "},
+		// Срок записи на индексе снапшота другой: лог отбрасывается целиком
+		{"conflicting", Snapshot{Index: 2, Term: 3}, ""},
+		// Снапшот дальше конца лога
+		{"beyond", Snapshot{Index: 10, Term: 3}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _ := openTestStorage(t, dir)
+			s.saveEntries(logOf(1, 1, 2, 2))
+			if err := s.saveSnapshot(c.snap); err != nil {
+				t.Fatal(err)
+			}
+			s.close()
+
+			_, hs := openTestStorage(t, dir)
+			if hs.snap.Index != c.snap.Index {
+				t.Fatalf("snapshot index %d, want %d", hs.snap.Index, c.snap.Index)
+			}
+			if got := termsOf(hs.entries); got != c.want {
+				t.Fatalf("entries %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCompactKeepsStateAndTail(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestStorage(t, dir)
+	s.saveState(3, "n2")
+	s.saveEntries(logOf(1, 1, 2, 3))
+	tail := logOf(1, 1, 2, 3)[2:]
+	if err := s.compact(3, "n2", Snapshot{Index: 2, Term: 1}, tail); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	_, hs := openTestStorage(t, dir)
+	if hs.term != 3 || hs.vote != "n2" {
+		t.Fatalf("term %d vote %q", hs.term, hs.vote)
+	}
+	if hs.snap.Index != 2 || termsOf(hs.entries) != "3:2 4:3 " {
+		t.Fatalf("snapshot %d, entries %q", hs.snap.Index, termsOf(hs.entries))
+	}
+}
+
+func TestFollowerTruncatesConflictingEntries(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestStorage(t, dir)
+	s.saveEntries(logOf(1, 1, 1))
+
+	host := &Host{electionTimeout: time.Second}
+	g := newGroup(0, host, s, hardState{term: 1, entries: logOf(1, 1, 1)})
+
+	// Лидер срока 2 согласен с записью 1, но записи 2 и 3 у него другие
+	resp, err := g.handleAppend(AppendRequest{
+		Term: 2, Leader: "n2", PrevIndex: 1, PrevTerm: 1,
+		Entries: []Entry{{Index: 2, Term: 2}},
+	})
+	if err != nil || !resp.Success || resp.LastIndex != 2 {
+		t.Fatalf("append: %+v, %v", resp, err)
+	}
+	if got := termsOf(g.entries); got != "1:1 2:2 " {
+		t.Fatalf("entries %q", got)
+	}
+
+	// Повтор уже принятых записей лог не укорачивает
+	resp, _ = g.handleAppend(AppendRequest{Term: 2, Leader: "n2", PrevIndex: 0, Entries: logOf(1)})
+	if !resp.Success || termsOf(g.entries) != "1:1 2:2 " {
+		t.Fatalf("duplicate append: %+v, entries %q", resp, termsOf(g.entries))
+	}
+
+	// Несогласованная предыдущая запись: лидер должен отступить
+	resp, _ = g.handleAppend(AppendRequest{Term: 2, Leader: "n2", PrevIndex: 2, PrevTerm: 1})
+	if resp.Success || resp.LastIndex != 1 {
+		t.Fatalf("mismatched prev: %+v", resp)
+	}
+
+	s.close()
+	_, hs := openTestStorage(t, dir)
+	if hs.term != 2 || termsOf(hs.entries) != "1:1 2:2 " {
+		t.Fatalf("after restart: term %d, entries %q", hs.term, termsOf(hs.entries))
+	}
+}
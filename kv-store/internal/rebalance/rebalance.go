@@ -3,35 +3,54 @@ package rebalance
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"kv-store/internal/handoff"
 	"kv-store/internal/hashring"
 	"kv-store/internal/kv"
+	"kv-store/internal/peer"
 )
 
+// retryDelay - пауза перед повторным циклом, если часть ключей не удалось перенести
+const retryDelay = 5 * time.Second
+
 type Service struct {
-	store  *kv.Store
-	ring   *hashring.HashRing
-	myID   hashring.NodeID
-	client *http.Client
+	store    *kv.Store
+	ring     *hashring.HashRing
+	myID     hashring.NodeID
+	replicas int
+	hints    *handoff.Service
+	client   *http.Client
 
 	triggerCh chan struct{}
+	drainCh   chan chan int
+	// draining - нода выводится из кластера: повторы запускает Drain
+	draining atomic.Bool
+	// running держит цикл миграции, Stop ждет его завершения
+	running sync.Mutex
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewService(store *kv.Store, ring *hashring.HashRing, myID hashring.NodeID) *Service {
+func NewService(store *kv.Store, ring *hashring.HashRing, myID hashring.NodeID, replicas int, hints *handoff.Service) *Service {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Service{
 		store:     store,
 		ring:      ring,
 		myID:      myID,
+		replicas:  replicas,
+		hints:     hints,
 		client:    &http.Client{Timeout: 5 * time.Second},
 		triggerCh: make(chan struct{}, 1),
+		drainCh:   make(chan chan int),
 		ctx:       ctx,
 		cancel:    cancel,
 	}
@@ -45,12 +64,18 @@ func (s *Service) Start() {
 			return
 		case <-s.triggerCh:
 			s.performMigration()
+		case done := <-s.drainCh:
+			done <- s.performMigration()
 		}
 	}
 }
 
+// Stop прерывает текущий цикл миграции и ждет его завершения. Ключи,
+// которые не успели перенести, остаются локально до следующего запуска.
 func (s *Service) Stop() {
 	s.cancel()
+	s.running.Lock()
+	defer s.running.Unlock()
 }
 
 func (s *Service) Trigger() {
@@ -60,59 +85,143 @@ func (s *Service) Trigger() {
 	}
 }
 
-func (s *Service) performMigration() {
+// Drain переносит все локальные ключи и подсказки на другие ноды, пока
+// локальное хранилище не опустеет. Вызывается при выводе ноды из кластера,
+// после того как она убрана из кольца. Если перенос прерван, нода снова
+// ребалансирует ключи как обычно.
+func (s *Service) Drain(ctx context.Context) (err error) {
+	s.draining.Store(true)
+	defer func() {
+		if err != nil {
+			s.draining.Store(false)
+		}
+	}()
+	for {
+		if s.ring.Size() == 0 {
+			return errors.New("no nodes to drain to")
+		}
+
+		done := make(chan int, 1)
+		select {
+		case s.drainCh <- done:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+		var failed int
+		select {
+		case failed = <-done:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+
+		left := len(s.store.KeysSnapshot())
+		hints := s.hints.Pending()
+		if left == 0 && hints == 0 {
+			log.Println("Drain finished, no local keys left")
+			return nil
+		}
+		log.Printf("Drain: %d keys (%d failed) and %d hints left, retrying in %v", left, failed, hints, retryDelay)
+		s.hints.Trigger()
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+}
+
+// performMigration раздает каждый локальный ключ всем его текущим репликам.
+// Ключи, для которых эта нода больше не является репликой, удаляются локально
+// только после того, как их подтвердили все новые реплики.
+// Возвращает число ключей, которые не удалось перенести.
+func (s *Service) performMigration() int {
+	s.running.Lock()
+	defer s.running.Unlock()
+
 	log.Println("Starting rebalance cycle...")
 	start := time.Now()
 	moved := 0
+	copied := 0
 	errors := 0
 
 	keys := s.store.KeysSnapshot()
 
 	for _, key := range keys {
 		if s.ctx.Err() != nil {
-			return
+			log.Printf("Rebalance interrupted after %v. Moved: %d, Copied: %d", time.Since(start), moved, copied)
+			return errors
 		}
 
-		ownerID, err := s.ring.PrimaryNode(key)
+		replicas, err := s.ring.ReplicaNodes(key, s.replicas)
 		if err != nil {
 			continue
 		}
 
-		if ownerID == s.myID {
+		entry, err := s.store.GetEntry(key)
+		if err == kv.ErrNotFound {
 			continue
 		}
 
-		val, err := s.store.Get(key)
-		if err == kv.ErrNotFound {
-			continue
+		owned := false
+		failed := false
+		for _, nodeID := range replicas {
+			if nodeID == s.myID {
+				owned = true
+				continue
+			}
+
+			targetAddr, ok := s.ring.GetNodeAddr(nodeID)
+			if !ok {
+				log.Printf("ERR: No addr for node %s", nodeID)
+				failed = true
+				continue
+			}
+
+			if err := s.moveKey(key, entry, targetAddr); err != nil {
+				// Ключ остается локально, пока владелец его не подтвердит,
+				// и переносится повторно в следующем цикле
+				log.Printf("Failed to move %s to %s: %v", key, targetAddr, err)
+				failed = true
+				continue
+			}
+			copied++
 		}
 
-		targetAddr, ok := s.ring.GetNodeAddr(ownerID)
-		if !ok {
-			log.Printf("ERR: No addr for node %s", ownerID)
+		if failed {
 			errors++
 			continue
 		}
-
-		if err := s.moveKey(key, val, targetAddr); err != nil {
-			log.Printf("ERR: Failed to move %s to %s: %v", key, targetAddr, err)
-			errors++
-		} else {
-			s.store.Delete(key)
+		if !owned {
+			if err := s.store.Delete(key); err != nil {
+				log.Printf("ERR: Failed to drop moved key %s: %v", key, err)
+				errors++
+				continue
+			}
 			moved++
 		}
 	}
 
-	log.Printf("Rebalance finished in %v. Moved: %d, Errors: %d", time.Since(start), moved, errors)
+	log.Printf("Rebalance finished in %v. Moved: %d, Copied: %d, Errors: %d", time.Since(start), moved, copied, errors)
+
+	if errors > 0 && !s.draining.Load() {
+		time.AfterFunc(retryDelay, s.Trigger)
+	}
+	return errors
 }
 
-func (s *Service) moveKey(key string, val []byte, targetAddr string) error {
-	url := fmt.Sprintf("http://%s/internal/put?key=%s", targetAddr, key)
+func (s *Service) moveKey(key string, entry kv.Entry, targetAddr string) error {
+	u := fmt.Sprintf("http://%s/internal/put?key=%s", targetAddr, url.QueryEscape(key))
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(val))
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPut, u, bytes.NewReader(entry.Value))
 	if err != nil {
 		return err
 	}
+	peer.SetEntryHeaders(req.Header, entry)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
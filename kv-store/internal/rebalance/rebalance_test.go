@@ -0,0 +1,31 @@
+package rebalance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kv-store/internal/kv"
+)
+
+func TestMoveKeyEscapesKey(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("key")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := NewService(nil, nil, "n1", 1, nil)
+	defer s.Stop()
+
+	for _, key := range []string{"a&b=c", "tag#1", "x+y", "100%", "with space", "путь/ключ"} {
+		if err := s.moveKey(key, kv.Entry{Value: []byte("v")}, strings.TrimPrefix(srv.URL, "http://")); err != nil {
+			t.Fatal(err)
+		}
+		if got != key {
+			t.Fatalf("moved %q as %q", key, got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package txn хранит журнал решений координатора двухфазных транзакций
+// и доводит до конца транзакции, прерванные падением или недоступностью участников.
+package txn
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"kv-store/internal/hashring"
+	"kv-store/internal/kv"
+	"kv-store/internal/wal"
+)
+
+// State - состояние транзакции в журнале координатора
+type State string
+
+const (
+	StatePrepare State = "prepare" // участникам разосланы prepare, решения еще нет
+	StateCommit  State = "commit"  // решение принято: все участники применяют запись
+	StateAbort   State = "abort"   // решение принято: участники снимают блокировки
+	StateDone    State = "done"    // все участники уведомлены
+)
+
+// Op - запись или удаление ключа в транзакции
+type Op struct {
+	Key       string `json:"key"`
+	Delete    bool   `json:"delete,omitempty"`
+	Value     []byte `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// Record - состояние транзакции. Participants - владельцы ключей
+// транзакции и операции над их ключами (у участника, ключи которого
+// только проверяются условиями, операций нет).
+type Record struct {
+	ID           string                   `json:"id"`
+	State        State                    `json:"state"`
+	Version      kv.Version               `json:"version"`
+	Participants map[hashring.NodeID][]Op `json:"participants,omitempty"`
+}
+
+// Log - журнал решений координатора. Запись решения в журнал (fsync)
+// и есть момент фиксации транзакции: после рестарта координатор
+// дочитывает журнал и доводит незавершенные транзакции до конца.
+type Log struct {
+	mu      sync.Mutex
+	wal     *wal.Log // nil - журнал только в памяти, без восстановления
+	pending map[string]Record
+	active  map[string]bool // транзакции, которые сейчас ведет обработчик запроса
+	dirty   bool
+}
+
+// OpenLog открывает журнал в dir и восстанавливает незавершенные транзакции.
+// Пустой dir - журнал только в памяти.
+func OpenLog(dir string) (*Log, error) {
+	l := &Log{
+		pending: make(map[string]Record),
+		active:  make(map[string]bool),
+	}
+	if dir == "" {
+		return l, nil
+	}
+
+	journal, err := wal.Open(wal.Options{Dir: dir, Sync: wal.SyncAlways}, func(_ uint64, payload []byte) error {
+		var rec Record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return fmt.Errorf("txn log: %w", err)
+		}
+		l.apply(rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.wal = journal
+	return l, nil
+}
+
+// Begin записывает новую транзакцию. Пока координатор ее ведет (до Release),
+// Pending ее не возвращает.
+func (l *Log) Begin(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.appendLocked(rec); err != nil {
+		return err
+	}
+	l.active[rec.ID] = true
+	return nil
+}
+
+// Update записывает новое состояние транзакции
+func (l *Log) Update(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appendLocked(rec)
+}
+
+// Release отдает транзакцию фоновому довыполнению, если она еще не завершена
+func (l *Log) Release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.active, id)
+}
+
+// Pending возвращает незавершенные транзакции, которые никто не ведет
+func (l *Log) Pending() []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []Record
+	for id, rec := range l.pending {
+		if !l.active[id] {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// Compact переносит незавершенные транзакции в новый сегмент журнала
+// и удаляет старые сегменты
+func (l *Log) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.wal == nil || !l.dirty {
+		return nil
+	}
+
+	if err := l.wal.Roll(); err != nil {
+		return err
+	}
+	upTo := l.wal.LastSeq()
+	for _, rec := range l.pending {
+		if err := l.appendLocked(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := l.wal.TruncateBefore(upTo); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) Close() error {
+	if l.wal == nil {
+		return nil
+	}
+	return l.wal.Close()
+}
+
+func (l *Log) appendLocked(rec Record) error {
+	if l.wal != nil {
+		payload, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := l.wal.Append(payload); err != nil {
+			return err
+		}
+		l.dirty = true
+	}
+	l.apply(rec)
+	return nil
+}
+
+func (l *Log) apply(rec Record) {
+	if rec.State == StateDone {
+		delete(l.pending, rec.ID)
+		return
+	}
+	// Запись решения не повторяет список участников
+	if prev, ok := l.pending[rec.ID]; ok && rec.Participants == nil {
+		rec.Participants = prev.Participants
+	}
+	l.pending[rec.ID] = rec
+}
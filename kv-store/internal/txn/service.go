@@ -0,0 +1,83 @@
+package txn
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// retryInterval - как часто повторять доставку решений недоступным участникам
+const retryInterval = 5 * time.Second
+
+// Resolver доводит транзакцию до конца: для prepare принимает решение abort,
+// для принятого решения рассылает его участникам и записывает done
+type Resolver func(ctx context.Context, rec Record) error
+
+// Service при старте и затем периодически довыполняет незавершенные транзакции из журнала
+type Service struct {
+	log     *Log
+	resolve Resolver
+
+	triggerCh chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewService(txnLog *Log, resolve Resolver) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		log:       txnLog,
+		resolve:   resolve,
+		triggerCh: make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (s *Service) Start() {
+	log.Println("Transaction recovery worker started")
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	s.resolvePending()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.triggerCh:
+			s.resolvePending()
+		case <-ticker.C:
+			s.resolvePending()
+		}
+	}
+}
+
+func (s *Service) Stop() {
+	s.cancel()
+}
+
+func (s *Service) Trigger() {
+	select {
+	case s.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) resolvePending() {
+	pending := s.log.Pending()
+	for _, rec := range pending {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err := s.resolve(s.ctx, rec); err != nil {
+			log.Printf("ERR: Failed to resolve transaction %s (%s): %v", rec.ID, rec.State, err)
+			continue
+		}
+		log.Printf("Resolved in-doubt transaction %s (%s)", rec.ID, rec.State)
+	}
+
+	if err := s.log.Compact(); err != nil {
+		log.Printf("ERR: Failed to compact transaction log: %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Формат записи: [длина payload, 4 байта][crc32c(seq+payload), 4 байта][seq, 8 байт][payload]
+const (
+	headerSize = 16
+
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+
+	defaultSegmentSize  = 64 << 20
+	maxRecordSize       = 256 << 20
+	defaultSyncInterval = 100 * time.Millisecond
+)
+
+var (
+	ErrCorrupt = errors.New("wal: corrupt record")
+	ErrClosed  = errors.New("wal: closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SyncPolicy - когда вызывать fsync после записи в лог
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync после каждой записи
+	SyncInterval SyncPolicy = "interval" // fsync в фоне раз в SyncInterval
+	SyncNever    SyncPolicy = "never"    // полагаемся на ОС
+)
+
+type Options struct {
+	Dir          string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+
+	// SegmentSize - размер файла, после которого лог переходит на новый сегмент
+	SegmentSize int64
+
+	// StartSeq - номер, уже покрытый снапшотом. Записи с seq <= StartSeq
+	// при открытии не проигрываются, а новые записи получают номера больше него.
+	StartSeq uint64
+}
+
+// Log - append-only журнал, разбитый на сегменты wal-<первый seq>.log
+type Log struct {
+	opts Options
+
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	seq   uint64
+	dirty bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open открывает журнал в opts.Dir и проигрывает все записи через apply.
+// Оборванная запись в конце последнего сегмента (падение во время записи)
+// отбрасывается.
+func Open(opts Options, apply func(seq uint64, payload []byte) error) (*Log, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	switch opts.Sync {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("wal: unknown sync policy %q", opts.Sync)
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{opts: opts, seq: opts.StartSeq, done: make(chan struct{})}
+
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		if err := l.replaySegment(seg.path, last, apply); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(segments) > 0 {
+		// Последний сегмент может быть пустым сразу после Roll
+		if first := segments[len(segments)-1].firstSeq; first > 0 && first-1 > l.seq {
+			l.seq = first - 1
+		}
+	}
+
+	if len(segments) == 0 {
+		if err := l.openSegment(l.seq + 1); err != nil {
+			return nil, err
+		}
+	} else {
+		path := segments[len(segments)-1].path
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		l.f = f
+		l.size = st.Size()
+	}
+
+	if opts.Sync == SyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// Append дописывает запись в журнал и возвращает ее порядковый номер
+func (l *Log) Append(payload []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return 0, ErrClosed
+	}
+
+	if l.size >= l.opts.SegmentSize {
+		if err := l.rollLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := l.seq + 1
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(buf[8:16], seq)
+	copy(buf[headerSize:], payload)
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], crcTable))
+
+	if _, err := l.f.Write(buf); err != nil {
+		return 0, err
+	}
+	l.seq = seq
+	l.size += int64(len(buf))
+
+	if l.opts.Sync == SyncAlways {
+		if err := l.f.Sync(); err != nil {
+			return 0, err
+		}
+	} else {
+		l.dirty = true
+	}
+	return seq, nil
+}
+
+// Roll закрывает текущий сегмент и начинает новый, чтобы все записанные
+// до этого момента записи можно было удалить через TruncateBefore
+func (l *Log) Roll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return ErrClosed
+	}
+	if l.size == 0 {
+		return nil
+	}
+	return l.rollLocked()
+}
+
+// TruncateBefore удаляет сегменты, все записи которых имеют seq <= upTo.
+// Текущий сегмент не удаляется никогда.
+func (l *Log) TruncateBefore(upTo uint64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := 0; i+1 < len(segments); i++ {
+		lastSeq := segments[i+1].firstSeq - 1
+		if lastSeq > upTo {
+			break
+		}
+		if err := os.Remove(segments[i].path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	if removed > 0 {
+		return removed, syncDir(l.opts.Dir)
+	}
+	return 0, nil
+}
+
+// LastSeq возвращает номер последней записи в журнале
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Sync сбрасывает журнал на диск
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncLocked()
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.f == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.done)
+	l.mu.Unlock()
+
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.syncLocked()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.Sync(); err != nil {
+				log.Printf("ERR: WAL sync failed: %v", err)
+			}
+		}
+	}
+}
+
+func (l *Log) syncLocked() error {
+	if l.f == nil || !l.dirty {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// rollLocked закрывает текущий сегмент и начинает новый со следующего seq
+func (l *Log) rollLocked() error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return l.openSegment(l.seq + 1)
+}
+
+func (l *Log) openSegment(firstSeq uint64) error {
+	path := filepath.Join(l.opts.Dir, segmentName(firstSeq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.opts.Dir); err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = 0
+	return nil
+}
+
+func (l *Log) replaySegment(path string, last bool, apply func(seq uint64, payload []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		offset int64
+		header [headerSize]byte
+	)
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return l.handleTorn(path, offset, last, err)
+		}
+
+		n := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		seq := binary.LittleEndian.Uint64(header[8:16])
+		if n > maxRecordSize {
+			return l.handleTorn(path, offset, last, ErrCorrupt)
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(f, payload); err != nil {
+			return l.handleTorn(path, offset, last, err)
+		}
+
+		crc := crc32.Update(crc32.Checksum(header[8:16], crcTable), crcTable, payload)
+		if crc != sum {
+			return l.handleTorn(path, offset, last, ErrCorrupt)
+		}
+
+		if seq > l.seq {
+			if err := apply(seq, payload); err != nil {
+				return err
+			}
+			l.seq = seq
+		}
+		offset += headerSize + int64(n)
+	}
+}
+
+// handleTorn обрезает хвост последнего сегмента после последней целой записи.
+// Повреждение в середине журнала считается ошибкой.
+func (l *Log) handleTorn(path string, offset int64, last bool, cause error) error {
+	if !last {
+		return fmt.Errorf("wal: %s at offset %d: %w", filepath.Base(path), offset, cause)
+	}
+	log.Printf("WAL: truncating torn tail of %s at offset %d (%v)", filepath.Base(path), offset, cause)
+	return os.Truncate(path, offset)
+}
+
+type segment struct {
+	path     string
+	firstSeq uint64
+}
+
+func (l *Log) segments() ([]segment, error) {
+	entries, err := os.ReadDir(l.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{path: filepath.Join(l.opts.Dir, name), firstSeq: first})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].firstSeq < segs[j].firstSeq })
+	return segs, nil
+}
+
+func segmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, firstSeq, segmentSuffix)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
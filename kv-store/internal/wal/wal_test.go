@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type record struct {
+	seq     uint64
+	payload string
+}
+
+func openLog(t *testing.T, opts Options) (*Log, []record) {
+	t.Helper()
+	var got []record
+	l, err := Open(opts, func(seq uint64, payload []byte) error {
+		got = append(got, record{seq, string(payload)})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, got
+}
+
+func appendN(t *testing.T, l *Log, from, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		if _, err := l.Append([]byte(fmt.Sprintf("rec-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no segments in %s: %v", dir, err)
+	}
+	return files[len(files)-1]
+}
+
+func TestReopenReplaysRecords(t *testing.T) {
+	opts := Options{Dir: t.TempDir(), Sync: SyncNever, SegmentSize: 64}
+	l, _ := openLog(t, opts)
+	appendN(t, l, 1, 10)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, got := openLog(t, opts)
+	defer l.Close()
+	if len(got) != 10 {
+		t.Fatalf("replayed %d records, want 10", len(got))
+	}
+	for i, r := range got {
+		if r.seq != uint64(i+1) || r.payload != fmt.Sprintf("rec-%d", i+1) {
+			t.Fatalf("record %d = %+v", i, r)
+		}
+	}
+	if seq, _ := l.Append([]byte("next")); seq != 11 {
+		t.Fatalf("next seq = %d, want 11", seq)
+	}
+}
+
+func TestTornTailIsTruncated(t *testing.T) {
+	opts := Options{Dir: t.TempDir(), Sync: SyncNever}
+	l, _ := openLog(t, opts)
+	appendN(t, l, 1, 3)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Падение посреди записи: заголовок есть, payload оборван
+	path := lastSegment(t, opts.Dir)
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intact := st.Size()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1, 2, 3, 4, 4, 0, 0, 0, 0, 0, 0, 0, 'x'})
+	f.Close()
+
+	l, got := openLog(t, opts)
+	if len(got) != 3 {
+		t.Fatalf("replayed %d records, want 3", len(got))
+	}
+	if st, _ := os.Stat(path); st.Size() != intact {
+		t.Fatalf("segment size %d after recovery, want %d", st.Size(), intact)
+	}
+
+	// Журнал продолжается после обрезанного хвоста
+	appendN(t, l, 4, 1)
+	l.Close()
+	l, got = openLog(t, opts)
+	defer l.Close()
+	if len(got) != 4 || got[3] != (record{4, "rec-4"}) {
+		t.Fatalf("after append: %+v", got)
+	}
+}
+
+func TestCorruptionBeforeLastSegmentFails(t *testing.T) {
+	opts := Options{Dir: t.TempDir(), Sync: SyncNever}
+	l, _ := openLog(t, opts)
+	appendN(t, l, 1, 3)
+	first := lastSegment(t, opts.Dir)
+	if err := l.Roll(); err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 4, 3)
+	l.Close()
+
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(first, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(opts, func(uint64, []byte) error { return nil })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Open = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestTruncateBeforeAndStartSeq(t *testing.T) {
+	opts := Options{Dir: t.TempDir(), Sync: SyncNever}
+	l, _ := openLog(t, opts)
+	appendN(t, l, 1, 3)
+	l.Roll()
+	appendN(t, l, 4, 3)
+	l.Roll()
+	appendN(t, l, 7, 3)
+
+	// Второй сегмент содержит seq 6 > 5, поэтому удаляется только первый
+	removed, err := l.TruncateBefore(5)
+	if err != nil || removed != 1 {
+		t.Fatalf("TruncateBefore = %d, %v; want 1", removed, err)
+	}
+	l.Close()
+
+	// Записи, покрытые снапшотом, не проигрываются
+	opts.StartSeq = 5
+	l, got := openLog(t, opts)
+	defer l.Close()
+	want := []record{{6, "rec-6"}, {7, "rec-7"}, {8, "rec-8"}, {9, "rec-9"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("replayed %+v, want %+v", got, want)
+	}
+}
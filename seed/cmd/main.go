@@ -1,26 +1,60 @@
 package main
 
 import (
+	"flag"
+	"log"
 	"net/http"
 	"seed/internal/cluster"
 	"seed/internal/handler"
+	"seed/internal/replication"
+	"strings"
 	"time"
 )
 
 func main() {
+	listen := flag.String("listen", ":9000", "адрес HTTP-сервера")
+	self := flag.String("self", "", "адрес этого seed для остальных seed группы (host:port)")
+	peers := flag.String("peers", "", "адреса всех seed группы через запятую, включая self; пусто - одиночный seed")
+	flag.Parse()
+
+	var members []string
+	if *peers != "" {
+		members = strings.Split(*peers, ",")
+		if *self == "" {
+			log.Fatal("-self is required with -peers")
+		}
+	}
+
 	// Инициализация Core
 	cluster := cluster.NewCluster()
+	group := replication.NewGroup(*self, members, cluster)
+	go group.Start()
 
-	// Фоновая очистка
+	// Фоновая очистка; ее выполняет лидер, и удаление реплицируется
 	go func() {
 		for range time.Tick(2 * time.Second) {
-			cluster.CleanUp()
+			since, ok := group.LeaderSince()
+			if !ok || !cluster.HasExpired(since) {
+				continue
+			}
+			err := group.Update(func() ([]byte, error) {
+				cluster.CleanUp(since)
+				return cluster.Snapshot()
+			})
+			if err != nil {
+				log.Printf("ERR: Cleanup replication failed: %v", err)
+			}
 		}
 	}()
 
 	// Роутинг
-	http.HandleFunc("/register", handler.Register(cluster))
-	http.HandleFunc("/heartbeat", handler.Heartbeat(cluster))
+	http.HandleFunc("/register", handler.Register(cluster, group))
+	http.HandleFunc("/heartbeat", handler.Heartbeat(cluster, group))
+	http.HandleFunc("/leave", handler.Leave(cluster, group))
+	http.HandleFunc("/deregister", handler.Deregister(cluster, group))
+	http.HandleFunc("/watch-topology", handler.WatchTopology(cluster, group))
+	http.HandleFunc("/replication/vote", handler.Vote(group))
+	http.HandleFunc("/replication/append", handler.Append(group))
 
-	http.ListenAndServe(":9000", nil)
+	http.ListenAndServe(*listen, nil)
 }
@@ -3,6 +3,8 @@ package cluster
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"seed/internal/entity"
 	"sync"
 	"time"
@@ -10,50 +12,193 @@ import (
 
 type Cluster struct {
 	nodes map[string]*entity.Node
-	mu    sync.RWMutex
+	// epoch - версия состава: растет при каждом изменении списка нод
+	epoch uint64
+	// changed закрывается и заменяется новым при каждом изменении эпохи
+	changed chan struct{}
+	mu      sync.RWMutex
 }
 
+// NewCluster создает пустой реестр. Эпоха начинается с текущего времени
+// в наносекундах, чтобы после перезапуска группы seed она не вернулась
+// к значениям, которые ноды уже видели.
 func NewCluster() *Cluster {
-	return &Cluster{nodes: make(map[string]*entity.Node)}
+	return &Cluster{
+		nodes:   make(map[string]*entity.Node),
+		epoch:   uint64(time.Now().UnixNano()),
+		changed: make(chan struct{}),
+	}
+}
+
+// Topology возвращает список нод, его эпоху и канал, который закроется
+// при следующем изменении состава
+func (c *Cluster) Topology() ([]entity.Node, uint64, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list(), c.epoch, c.changed
 }
 
-func (c *Cluster) Register(addr string) string {
-	id := generateID()
+func (c *Cluster) bump() {
+	c.epoch++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// ErrIDInUse - id занят живой нодой с другим адресом
+var ErrIDInUse = errors.New("node id is in use by another node")
+
+// Register добавляет ноду. Пустой id - seed выдает новый; иначе нода
+// возвращается под прежним id (например, после перезапуска) и сохраняет
+// позиции на кольце.
+func (c *Cluster) Register(addr, id string) (string, error) {
+	if id == "" {
+		id = generateID()
+	}
 	c.mu.Lock()
-	c.nodes[id] = &entity.Node{ID: id, LastSeen: time.Now(), Addr: addr}
-	c.mu.Unlock()
-	return id
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if n, exists := c.nodes[id]; exists && n.Addr != addr && now.Sub(n.LastSeen) <= nodeTimeout {
+		return "", ErrIDInUse
+	}
+	if n, exists := c.nodes[id]; !exists || n.Addr != addr || n.Leaving {
+		c.bump()
+	}
+	c.nodes[id] = &entity.Node{ID: id, LastSeen: now, Addr: addr}
+	return id, nil
 }
 
-// Heartbeat - обновление статуса и возврат живых нод
-func (c *Cluster) Heartbeat(id string) ([]entity.Node, bool) {
+// Heartbeat - обновление статуса и возврат живых нод с эпохой состава
+func (c *Cluster) Heartbeat(id string) ([]entity.Node, uint64, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if node, exists := c.nodes[id]; exists {
 		node.LastSeen = time.Now()
 	} else {
-		return nil, false
+		return nil, 0, false
+	}
+
+	return c.list(), c.epoch, true
+}
+
+// Leave помечает ноду как выводимую из кластера. Она остается в списке
+// (чтобы соседи могли принять ее данные), пока шлет heartbeat.
+func (c *Cluster) Leave(id string) ([]entity.Node, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, exists := c.nodes[id]
+	if !exists {
+		return nil, 0, false
+	}
+	if !node.Leaving {
+		node.Leaving = true
+		c.bump()
 	}
+	node.LastSeen = time.Now()
 
-	// Собираем список всех
-	active := make([]entity.Node, 0)
+	return c.list(), c.epoch, true
+}
+
+// list - копия списка всех нод
+func (c *Cluster) list() []entity.Node {
+	active := make([]entity.Node, 0, len(c.nodes))
 	for _, n := range c.nodes {
 		active = append(active, *n)
 	}
-	return active, true
+	return active
+}
+
+// Deregister удаляет ноду сразу, не дожидаясь CleanUp. false - нода неизвестна.
+func (c *Cluster) Deregister(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.nodes[id]; !exists {
+		return false
+	}
+	delete(c.nodes, id)
+	c.bump()
+	return true
+}
+
+// nodeTimeout - через сколько без heartbeat нода считается неактивной
+const nodeTimeout = 15 * time.Second
+
+// HasExpired - есть ли ноды без heartbeat дольше nodeTimeout. since - момент,
+// с которого этот seed принимает heartbeat (стал лидером): раньше него
+// отсутствие heartbeat не учитывается.
+func (c *Cluster) HasExpired(since time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	for _, n := range c.nodes {
+		if expired(n, since, now) {
+			return true
+		}
+	}
+	return false
 }
 
 // CleanUp - удаление старых
-func (c *Cluster) CleanUp() {
+func (c *Cluster) CleanUp(since time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	now := time.Now()
 	for id, n := range c.nodes {
-		if now.Sub(n.LastSeen) > 15*time.Second {
+		if expired(n, since, now) {
 			delete(c.nodes, id)
+			c.bump()
+		}
+	}
+}
+
+func expired(n *entity.Node, since, now time.Time) bool {
+	last := n.LastSeen
+	if since.After(last) {
+		last = since
+	}
+	return now.Sub(last) > nodeTimeout
+}
+
+// snapshot - реплицируемое состояние реестра
+type snapshot struct {
+	Epoch uint64        `json:"epoch"`
+	Nodes []entity.Node `json:"nodes"`
+}
+
+// Snapshot сериализует реестр нод для реплик seed
+func (c *Cluster) Snapshot() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.Marshal(snapshot{Epoch: c.epoch, Nodes: c.list()})
+}
+
+// Restore заменяет реестр состоянием, которое прислал лидер группы seed
+func (c *Cluster) Restore(data []byte) error {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch != snap.Epoch {
+		c.epoch = snap.Epoch
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	restored := make(map[string]*entity.Node, len(snap.Nodes))
+	for _, n := range snap.Nodes {
+		n := n
+		if old, ok := c.nodes[n.ID]; ok {
+			n.LastSeen = old.LastSeen
 		}
+		restored[n.ID] = &n
 	}
+	c.nodes = restored
+	return nil
 }
 
 func generateID() string {
@@ -3,7 +3,10 @@ package entity
 import "time"
 
 type Node struct {
-	ID       string
-	LastSeen time.Time
-	Addr     string
+	ID string `json:"id"`
+	// LastSeen - время последнего heartbeat; известно только лидеру группы seed
+	LastSeen time.Time `json:"-"`
+	Addr     string    `json:"addr"`
+	// Leaving - нода выводится из кластера: она еще отвечает, но не владеет ключами
+	Leaving bool `json:"leaving,omitempty"`
 }
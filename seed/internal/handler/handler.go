@@ -2,12 +2,22 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"seed/internal/cluster"
+	"seed/internal/entity"
+	"seed/internal/replication"
+	"strconv"
+	"time"
 )
 
+// RegisterReq - id, под которым нода хочет зарегистрироваться; пустой - выдаст seed
+type RegisterReq struct {
+	ID string `json:"id"`
+}
 type RegisterResp struct {
 	ID string `json:"id"`
 }
@@ -15,12 +25,16 @@ type HeartbeatReq struct {
 	ID string `json:"id"`
 }
 type NodeDTO struct {
-	ID   string `json:"id"`
-	Addr string `json:"addr"`
+	ID      string `json:"id"`
+	Addr    string `json:"addr"`
+	Leaving bool   `json:"leaving,omitempty"`
 }
 
-func Register(uc *cluster.Cluster) http.HandlerFunc {
+func Register(uc *cluster.Cluster, rg *replication.Group) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !toLeader(w, r, rg) {
+			return
+		}
 		host, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			host = r.RemoteAddr
@@ -28,28 +42,234 @@ func Register(uc *cluster.Cluster) http.HandlerFunc {
 
 		realAddr := fmt.Sprintf("%s:8080", host)
 
-		id := uc.Register(realAddr)
+		var req RegisterReq
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ID != "" && !validID(req.ID) {
+			http.Error(w, "invalid node id", http.StatusBadRequest)
+			return
+		}
+
+		var id string
+		var regErr error
+		err = rg.Update(func() ([]byte, error) {
+			id, regErr = uc.Register(realAddr, req.ID)
+			return uc.Snapshot()
+		})
+		if !replicated(w, err) {
+			return
+		}
+		if regErr != nil {
+			http.Error(w, regErr.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Node %s registered at %s", id, realAddr)
 
 		json.NewEncoder(w).Encode(RegisterResp{ID: id})
 	}
 }
 
-func Heartbeat(uc *cluster.Cluster) http.HandlerFunc {
+// Heartbeat принимает только лидер: время heartbeat не реплицируется
+func Heartbeat(uc *cluster.Cluster, rg *replication.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !toLeader(w, r, rg) {
+			return
+		}
+		var req HeartbeatReq
+		json.NewDecoder(r.Body).Decode(&req)
+
+		nodes, epoch, ok := uc.Heartbeat(req.ID)
+		if !ok {
+			http.Error(w, "Unknown node", 401)
+			return
+		}
+
+		writeNodes(w, nodes, epoch)
+	}
+}
+
+// Leave - нода начинает вывод из кластера и больше не получает ключей
+func Leave(uc *cluster.Cluster, rg *replication.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !toLeader(w, r, rg) {
+			return
+		}
+		var req HeartbeatReq
+		json.NewDecoder(r.Body).Decode(&req)
+
+		var nodes []entity.Node
+		var epoch uint64
+		ok := true
+		err := rg.Update(func() ([]byte, error) {
+			nodes, epoch, ok = uc.Leave(req.ID)
+			return uc.Snapshot()
+		})
+		if !replicated(w, err) {
+			return
+		}
+		if !ok {
+			http.Error(w, "Unknown node", 401)
+			return
+		}
+		log.Printf("Node %s is leaving the cluster", req.ID)
+
+		writeNodes(w, nodes, epoch)
+	}
+}
+
+// Deregister - нода останавливается и сразу исчезает из списка активных
+func Deregister(uc *cluster.Cluster, rg *replication.Group) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !toLeader(w, r, rg) {
+			return
+		}
 		var req HeartbeatReq
 		json.NewDecoder(r.Body).Decode(&req)
 
-		nodes, ok := uc.Heartbeat(req.ID)
+		ok := true
+		err := rg.Update(func() ([]byte, error) {
+			ok = uc.Deregister(req.ID)
+			return uc.Snapshot()
+		})
+		if !replicated(w, err) {
+			return
+		}
 		if !ok {
 			http.Error(w, "Unknown node", 401)
 			return
 		}
+		log.Printf("Node %s deregistered", req.ID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeNodes отвечает списком нод и эпохой состава: нода с большей эпохой
+// знает более новый состав
+func writeNodes(w http.ResponseWriter, nodes []entity.Node, epoch uint64) {
+	dtos := make([]NodeDTO, len(nodes))
+	for i, n := range nodes {
+		dtos[i] = NodeDTO{ID: n.ID, Addr: n.Addr, Leaving: n.Leaving}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"epoch": epoch, "active_nodes": dtos})
+}
+
+// validID: латиница, цифры, '-', '_' и '.', не длиннее 64 символов.
+// Id входит в ключи виртуальных нод кольца ("id#n"), поэтому '#' запрещен.
+func validID(id string) bool {
+	if len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// watchTimeout - сколько WatchTopology ждет изменения, прежде чем ответить тем же составом
+const watchTimeout = 30 * time.Second
+
+// WatchTopology - long-poll состава кластера: отвечает, как только эпоха
+// станет отличаться от since, или через watchTimeout с текущим составом
+func WatchTopology(uc *cluster.Cluster, rg *replication.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !toLeader(w, r, rg) {
+			return
+		}
+		var since uint64
+		if raw := r.URL.Query().Get("since"); raw != "" {
+			v, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				http.Error(w, "bad since", http.StatusBadRequest)
+				return
+			}
+			since = v
+		}
+
+		timer := time.NewTimer(watchTimeout)
+		defer timer.Stop()
+		for {
+			nodes, epoch, changed := uc.Topology()
+			if epoch != since {
+				writeNodes(w, nodes, epoch)
+				return
+			}
+			select {
+			case <-changed:
+			case <-timer.C:
+				writeNodes(w, nodes, epoch)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// toLeader перенаправляет запрос лидеру группы seed, если этот seed не лидер.
+// false - ответ уже отправлен.
+func toLeader(w http.ResponseWriter, r *http.Request, rg *replication.Group) bool {
+	if rg.IsLeader() {
+		return true
+	}
+	leader, ok := rg.Leader()
+	if !ok {
+		http.Error(w, "no seed leader", http.StatusServiceUnavailable)
+		return false
+	}
+	http.Redirect(w, r, "http://"+leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	return false
+}
+
+// replicated отвечает ошибкой, если изменение не подтвердила группа seed
+func replicated(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, replication.ErrNotLeader), errors.Is(err, replication.ErrNoQuorum):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Printf("ERR: Replicating cluster state failed: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+	return false
+}
 
-		dtos := make([]NodeDTO, len(nodes))
-		for i, n := range nodes {
-			dtos[i] = NodeDTO{ID: n.ID, Addr: n.Addr}
+// Vote и Append - RPC между seed группы
+func Vote(rg *replication.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req replication.VoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
 		}
+		json.NewEncoder(w).Encode(rg.HandleVote(req))
+	}
+}
 
-		json.NewEncoder(w).Encode(map[string]interface{}{"active_nodes": dtos})
+func Append(rg *replication.Group) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req replication.AppendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(rg.HandleAppend(req))
 	}
 }
@@ -0,0 +1,564 @@
+// Package replication реплицирует состояние seed (реестр нод) на группу из
+// 3 или 5 seed. Это упрощенный Raft: лидер выбирается большинством голосов
+// в своем сроке (term), но вместо лога реплицируется состояние целиком -
+// реестр маленький, и каждая новая версия заменяет предыдущую.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader - этот seed не лидер; запрос нужно отправить лидеру
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrNoQuorum - изменение не подтвердило большинство группы
+	ErrNoQuorum = errors.New("no quorum")
+)
+
+const (
+	heartbeatInterval = 200 * time.Millisecond
+	electionTimeout   = time.Second
+	rpcTimeout        = 500 * time.Millisecond
+	commitTimeout     = 2 * time.Second
+
+	// voteQuietPeriod - сколько seed после запуска не отдает голос (см. HandleVote)
+	voteQuietPeriod = electionTimeout
+)
+
+// Version - версия состояния: срок лидера, который ее создал, и номер изменения
+type Version struct {
+	Term  uint64 `json:"term"`
+	Index uint64 `json:"index"`
+}
+
+func (v Version) Less(o Version) bool {
+	if v.Term != o.Term {
+		return v.Term < o.Term
+	}
+	return v.Index < o.Index
+}
+
+// StateMachine - реплицируемое состояние: лидер рассылает Snapshot,
+// последователи принимают его через Restore
+type StateMachine interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+type VoteRequest struct {
+	Term      uint64  `json:"term"`
+	Candidate string  `json:"candidate"`
+	Version   Version `json:"version"`
+	// PreVote - предварительное голосование: голос ни к чему не обязывает
+	PreVote bool `json:"pre_vote,omitempty"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest - heartbeat лидера. State передается, только если
+// последователь еще не подтвердил Version.
+type AppendRequest struct {
+	Term    uint64  `json:"term"`
+	Leader  string  `json:"leader"`
+	Version Version `json:"version"`
+	State   []byte  `json:"state,omitempty"`
+}
+
+type AppendResponse struct {
+	Term    uint64  `json:"term"`
+	Success bool    `json:"success"`
+	Version Version `json:"version"`
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Group - участие этого seed в группе. Члены группы идентифицируются
+// адресами host:port, по которым они доступны друг другу и kv-нодам.
+type Group struct {
+	self   string
+	peers  []string
+	fsm    StateMachine
+	client *http.Client
+
+	// updateMu - изменения состояния применяются по одному
+	updateMu sync.Mutex
+
+	// startedAt - момент запуска: term и votedFor хранятся только в памяти
+	startedAt time.Time
+
+	mu          sync.Mutex
+	term        uint64
+	votedFor    string
+	role        role
+	leader      string
+	leaderSince time.Time
+	lastContact time.Time
+	deadline    time.Time
+	version     Version
+	state       []byte
+	acked       map[string]Version   // лидер: версия, которую подтвердил последователь
+	ackedAt     map[string]time.Time // лидер: когда последователь последний раз ответил
+	inflight    map[string]bool
+	changed     chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewGroup создает участника группы members (включая self). Пустой
+// members - одиночный seed, который сразу считает себя лидером.
+func NewGroup(self string, members []string, fsm StateMachine) *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &Group{
+		self:      self,
+		startedAt: time.Now(),
+		fsm:       fsm,
+		client:    &http.Client{Timeout: rpcTimeout},
+		acked:     make(map[string]Version),
+		ackedAt:   make(map[string]time.Time),
+		inflight:  make(map[string]bool),
+		changed:   make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for _, m := range members {
+		if m != self && !contains(g.peers, m) {
+			g.peers = append(g.peers, m)
+		}
+	}
+	g.resetDeadline()
+	return g
+}
+
+func (g *Group) Start() {
+	log.Printf("Replication started: self %s, peers %v", g.self, g.peers)
+	if len(g.peers) == 0 {
+		g.mu.Lock()
+		g.campaign()
+		g.mu.Unlock()
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+			g.tick()
+		}
+	}
+}
+
+func (g *Group) Stop() {
+	g.cancel()
+}
+
+// Leader возвращает адрес известного лидера
+func (g *Group) Leader() (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.leader, g.leader != ""
+}
+
+func (g *Group) IsLeader() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.role == leader
+}
+
+// LeaderSince возвращает, с какого момента этот seed лидер. Сведения о
+// живости нод (heartbeat) не реплицируются, и новый лидер отсчитывает их от этого момента.
+func (g *Group) LeaderSince() (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.leaderSince, g.role == leader
+}
+
+// Update применяет изменение на лидере и ждет, пока новое состояние
+// подтвердит большинство группы. apply меняет состояние и возвращает его
+// сериализованным. Пока изменение не подтверждено, лидер уже отдает его
+// в ответах. Если подтверждения нет, лидер откатывает состояние к версии
+// до изменения и уходит в последователи: следующий лидер не должен
+// расходиться с тем, что видели клиенты, а последователь, принявший
+// изменение, новее и выиграет выборы у бывшего лидера.
+func (g *Group) Update(apply func() ([]byte, error)) (err error) {
+	g.updateMu.Lock()
+	defer g.updateMu.Unlock()
+
+	g.mu.Lock()
+	if g.role != leader {
+		g.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := g.term
+	g.mu.Unlock()
+
+	data, err := apply()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var target Version
+	prevVersion, prevState := g.version, g.state
+	defer func() {
+		if err == nil {
+			return
+		}
+		if target != (Version{}) && g.version == target {
+			g.version, g.state = prevVersion, prevState
+		}
+		g.restore()
+	}()
+	if err != nil {
+		return err
+	}
+	if g.role != leader || g.term != term {
+		return ErrNotLeader
+	}
+	g.version = Version{Term: term, Index: g.version.Index + 1}
+	g.state = data
+	target = g.version
+	g.broadcast()
+
+	timer := time.NewTimer(commitTimeout)
+	defer timer.Stop()
+	for !g.committed(target) {
+		changed := g.changed
+		g.mu.Unlock()
+		expired := false
+		select {
+		case <-changed:
+		case <-timer.C:
+			expired = true
+		case <-g.ctx.Done():
+		}
+		g.mu.Lock()
+		switch {
+		case g.role != leader || g.term != term:
+			return ErrNotLeader
+		case g.ctx.Err() != nil:
+			return g.ctx.Err()
+		case expired && !g.committed(target):
+			log.Printf("Replication: leader %s could not commit version %v, stepping down", g.self, target)
+			g.becomeFollower(g.term, "")
+			return ErrNoQuorum
+		}
+	}
+	return nil
+}
+
+// restore возвращает конечный автомат к состоянию, известному группе,
+// после неудачного Update: к версии до изменения или к состоянию нового
+// лидера, если оно уже пришло. Вызывается под g.mu.
+func (g *Group) restore() {
+	if g.state == nil {
+		return
+	}
+	if err := g.fsm.Restore(g.state); err != nil {
+		log.Printf("ERR: Replication: restore state %v after failed update: %v", g.version, err)
+	}
+}
+
+// committed: версию v подтвердило большинство группы
+func (g *Group) committed(v Version) bool {
+	n := 1
+	for _, p := range g.peers {
+		if !g.acked[p].Less(v) {
+			n++
+		}
+	}
+	return n >= g.quorum()
+}
+
+func (g *Group) quorum() int {
+	return (len(g.peers)+1)/2 + 1
+}
+
+func (g *Group) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *Group) resetDeadline() {
+	g.deadline = time.Now().Add(electionTimeout + time.Duration(rand.Int63n(int64(electionTimeout))))
+}
+
+func (g *Group) tick() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if g.role != leader {
+		if now.After(g.deadline) {
+			g.campaign()
+		}
+		return
+	}
+	if now.Sub(g.leaderSince) > electionTimeout && !g.heardFromQuorum(now.Add(-electionTimeout)) {
+		// Лидер в меньшинстве не должен продолжать принимать heartbeat нод
+		log.Printf("Replication: leader %s lost contact with quorum, stepping down", g.self)
+		g.becomeFollower(g.term, "")
+		return
+	}
+	g.broadcast()
+}
+
+func (g *Group) heardFromQuorum(since time.Time) bool {
+	n := 1
+	for _, p := range g.peers {
+		if g.ackedAt[p].After(since) {
+			n++
+		}
+	}
+	return n >= g.quorum()
+}
+
+func (g *Group) becomeFollower(term uint64, leaderAddr string) {
+	if term > g.term {
+		g.term = term
+		g.votedFor = ""
+	}
+	if g.role == leader {
+		g.leaderSince = time.Time{}
+	}
+	g.role = follower
+	g.leader = leaderAddr
+	g.resetDeadline()
+	g.notify()
+}
+
+// campaign начинает выборы с предварительного голосования: срок повышается,
+// только если большинство готово проголосовать. Так seed, отрезанный от
+// группы, не сбивает живого лидера, когда возвращается.
+func (g *Group) campaign() {
+	g.resetDeadline()
+	g.requestVotes(true)
+}
+
+func (g *Group) requestVotes(pre bool) {
+	if !pre {
+		g.role = candidate
+		g.term++
+		g.votedFor = g.self
+		g.leader = ""
+	}
+	base := g.term
+	term := base
+	if pre {
+		term++
+	}
+
+	votes := 1
+	won := func() {
+		if pre {
+			g.requestVotes(false)
+		} else {
+			g.becomeLeader()
+		}
+	}
+	if votes >= g.quorum() {
+		won()
+		return
+	}
+
+	req := VoteRequest{Term: term, Candidate: g.self, Version: g.version, PreVote: pre}
+	for _, p := range g.peers {
+		go func(p string) {
+			var resp VoteResponse
+			if err := g.call(p, "/replication/vote", req, &resp); err != nil {
+				return
+			}
+
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if resp.Term > g.term {
+				g.becomeFollower(resp.Term, "")
+				return
+			}
+			if !resp.Granted || g.term != base || g.role == leader || !pre && g.role != candidate {
+				return
+			}
+			votes++
+			if votes == g.quorum() {
+				won()
+			}
+		}(p)
+	}
+}
+
+func (g *Group) becomeLeader() {
+	log.Printf("Replication: %s became leader for term %d", g.self, g.term)
+	g.role = leader
+	g.leader = g.self
+	g.leaderSince = time.Now()
+	g.acked = make(map[string]Version)
+	g.ackedAt = make(map[string]time.Time)
+
+	// Новая версия в своем сроке: состояние лидера заменит у последователей
+	// изменения прежнего лидера, которые не успело подтвердить большинство
+	g.version = Version{Term: g.term, Index: g.version.Index + 1}
+	if data, err := g.fsm.Snapshot(); err == nil {
+		g.state = data
+	} else {
+		log.Printf("ERR: Replication: snapshot failed: %v", err)
+	}
+	g.notify()
+	g.broadcast()
+}
+
+// broadcast отправляет heartbeat всем последователям, у которых нет запроса в полете
+func (g *Group) broadcast() {
+	for _, p := range g.peers {
+		if g.inflight[p] {
+			continue
+		}
+		req := AppendRequest{Term: g.term, Leader: g.self, Version: g.version}
+		if g.acked[p] != g.version {
+			req.State = g.state
+		}
+		g.inflight[p] = true
+		go g.sendAppend(p, req)
+	}
+}
+
+func (g *Group) sendAppend(p string, req AppendRequest) {
+	var resp AppendResponse
+	err := g.call(p, "/replication/append", req, &resp)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inflight[p] = false
+	if err != nil {
+		return
+	}
+	if resp.Term > g.term {
+		g.becomeFollower(resp.Term, "")
+		return
+	}
+	if g.role != leader || g.term != req.Term {
+		return
+	}
+	g.ackedAt[p] = time.Now()
+	if resp.Success {
+		g.acked[p] = resp.Version
+	}
+	g.notify()
+}
+
+// HandleVote отвечает кандидату. Seed, который недавно слышал лидера,
+// не голосует: так вернувшийся после разрыва seed не сбивает живого лидера.
+//
+// Срок и голос не переживают перезапуск, поэтому первые voteQuietPeriod
+// после запуска seed голосует только в предварительном голосовании.
+// Запросы голоса рассылаются один раз в начале срока и живут не дольше
+// rpcTimeout, так что к концу паузы выборы, в которых seed мог голосовать
+// до перезапуска, уже не принимают голосов, и второй голос в том же сроке
+// не выберет второго лидера.
+func (g *Group) HandleVote(req VoteRequest) VoteResponse {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req.Term < g.term {
+		return VoteResponse{Term: g.term}
+	}
+	now := time.Now()
+	if !req.PreVote && now.Sub(g.startedAt) < voteQuietPeriod {
+		return VoteResponse{Term: g.term}
+	}
+	if g.role == leader && g.heardFromQuorum(now.Add(-electionTimeout)) ||
+		g.role == follower && g.leader != "" && now.Sub(g.lastContact) < electionTimeout {
+		return VoteResponse{Term: g.term}
+	}
+	if req.PreVote {
+		return VoteResponse{Term: g.term, Granted: req.Term > g.term && !req.Version.Less(g.version)}
+	}
+	if req.Term > g.term {
+		g.becomeFollower(req.Term, "")
+	}
+
+	granted := (g.votedFor == "" || g.votedFor == req.Candidate) && !req.Version.Less(g.version)
+	if granted {
+		g.votedFor = req.Candidate
+		g.resetDeadline()
+	}
+	return VoteResponse{Term: g.term, Granted: granted}
+}
+
+// HandleAppend принимает heartbeat лидера и, если он прислал, его состояние
+func (g *Group) HandleAppend(req AppendRequest) AppendResponse {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req.Term < g.term {
+		return AppendResponse{Term: g.term, Version: g.version}
+	}
+	if req.Term > g.term || g.role != follower || g.leader != req.Leader {
+		if g.leader != req.Leader {
+			log.Printf("Replication: following leader %s (term %d)", req.Leader, req.Term)
+		}
+		g.becomeFollower(req.Term, req.Leader)
+	}
+	g.lastContact = time.Now()
+	g.resetDeadline()
+
+	if req.State != nil {
+		if err := g.fsm.Restore(req.State); err != nil {
+			log.Printf("ERR: Replication: restore state %v failed: %v", req.Version, err)
+			return AppendResponse{Term: g.term, Version: g.version}
+		}
+		g.version = req.Version
+		g.state = req.State
+	}
+	return AppendResponse{Term: g.term, Success: true, Version: g.version}
+}
+
+func (g *Group) call(addr, path string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodPost, "http://"+addr+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type nopState struct{}
+
+func (nopState) Snapshot() ([]byte, error) { return []byte("{}"), nil }
+func (nopState) Restore([]byte) error      { return nil }
+
+func TestNoVoteRightAfterStart(t *testing.T) {
+	g := NewGroup("a:9000", []string{"a:9000", "b:9000", "c:9000"}, nopState{})
+
+	pre := g.HandleVote(VoteRequest{Term: 1, Candidate: "b:9000", PreVote: true})
+	if !pre.Granted {
+		t.Fatal("pre-vote refused right after start")
+	}
+	if resp := g.HandleVote(VoteRequest{Term: 1, Candidate: "b:9000"}); resp.Granted {
+		t.Fatal("vote granted before the quiet period ended")
+	}
+
+	g.startedAt = time.Now().Add(-voteQuietPeriod)
+	if resp := g.HandleVote(VoteRequest{Term: 1, Candidate: "b:9000"}); !resp.Granted {
+		t.Fatal("vote refused after the quiet period")
+	}
+	if resp := g.HandleVote(VoteRequest{Term: 1, Candidate: "c:9000"}); resp.Granted {
+		t.Fatal("second vote granted in the same term")
+	}
+}
+
+// counter - конечный автомат для проверки отката
+type counter struct{ n int }
+
+func (c *counter) Snapshot() ([]byte, error) { return []byte(strconv.Itoa(c.n)), nil }
+func (c *counter) Restore(data []byte) (err error) {
+	c.n, err = strconv.Atoi(string(data))
+	return err
+}
+
+func TestUpdateWithoutQuorumRollsBack(t *testing.T) {
+	// Остальные seed группы недоступны
+	fsm := &counter{}
+	g := NewGroup("a:9000", []string{"a:9000", "127.0.0.1:1", "127.0.0.1:2"}, fsm)
+	defer g.Stop()
+
+	g.mu.Lock()
+	g.becomeLeader()
+	before := g.version
+	g.mu.Unlock()
+
+	err := g.Update(func() ([]byte, error) {
+		fsm.n++
+		return fsm.Snapshot()
+	})
+	if !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("Update = %v, want ErrNoQuorum", err)
+	}
+	if fsm.n != 0 {
+		t.Fatalf("uncommitted change kept on the leader: n = %d", fsm.n)
+	}
+	if g.IsLeader() {
+		t.Fatal("leader without quorum did not step down")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.version != before || string(g.state) != "0" {
+		t.Fatalf("version %v state %q, want %v and the state before the update", g.version, g.state, before)
+	}
+}
+
+func TestUpdateSingleSeed(t *testing.T) {
+	fsm := &counter{}
+	g := NewGroup("", nil, fsm)
+	defer g.Stop()
+	g.mu.Lock()
+	g.campaign()
+	g.mu.Unlock()
+
+	err := g.Update(func() ([]byte, error) {
+		fsm.n++
+		return fsm.Snapshot()
+	})
+	if err != nil || fsm.n != 1 {
+		t.Fatalf("Update = %v, n = %d", err, fsm.n)
+	}
+}